@@ -25,6 +25,9 @@ const (
 	KindCron       = "cron"
 	KindWorker     = "worker"
 	KindVolume     = "volume"
+	KindSlice      = "slice"
+	KindEnv        = "env"
+	KindLocation   = "location"
 )
 
 func NewBuilder(kind string, p *project.Config, s *server.Config) *Builder {
@@ -40,14 +43,28 @@ type Builder struct {
 	scope string
 	s     *server.Config
 	p     *project.Config
+	// Overrides the build path from server configuration, when
+	// non-empty; see Scratch().
+	buildPath string
+}
+
+// Returns a copy of the builder that builds into the given directory
+// instead of the configured build path. This allows to preview build
+// results without touching what is currently built.
+func (b Builder) Scratch(buildPath string) *Builder {
+	b.buildPath = buildPath
+	return &b
 }
 
 func (b Builder) Path() string {
+	if b.buildPath != "" {
+		return filepath.Join(b.buildPath, b.kind, b.p.ID)
+	}
 	return filepath.Join(b.s.BuildPath, b.kind, b.p.ID)
 }
 
 func (b Builder) ListAvailable() ([]string, error) {
-	path := filepath.Join(b.s.BuildPath, b.kind, b.p.ID)
+	path := b.Path()
 	files := make([]string, 0)
 
 	if _, err := os.Stat(path); os.IsNotExist(err) {
@@ -60,7 +77,7 @@ func (b Builder) ListAvailable() ([]string, error) {
 }
 
 func (b Builder) Clean() error {
-	dir := filepath.Join(b.s.BuildPath, b.kind, b.p.ID)
+	dir := b.Path()
 
 	if err := os.RemoveAll(dir); err != nil {
 		return fmt.Errorf("failed cleaning build directory %s: %s", dir, err)
@@ -69,7 +86,7 @@ func (b Builder) Clean() error {
 }
 
 func (b Builder) WriteFile(name string, reader io.Reader) error {
-	dir := filepath.Join(b.s.BuildPath, b.kind, b.p.ID)
+	dir := b.Path()
 
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -93,7 +110,7 @@ func (b Builder) LoadTemplate(name string) (*template.Template, error) {
 }
 
 func (b Builder) WriteTemplate(name string, t *template.Template, tmplData interface{}) error {
-	dir := filepath.Join(b.s.BuildPath, b.kind, b.p.ID)
+	dir := b.Path()
 
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -104,7 +121,7 @@ func (b Builder) WriteTemplate(name string, t *template.Template, tmplData inter
 }
 
 func (b Builder) WriteSensitiveTemplate(name string, t *template.Template, tmplData interface{}) error {
-	dir := filepath.Join(b.s.BuildPath, b.kind, b.p.ID)
+	dir := b.Path()
 
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		if err := os.MkdirAll(dir, 0750); err != nil {
@@ -118,7 +135,7 @@ func (b Builder) WriteSensitiveTemplate(name string, t *template.Template, tmplD
 // generates files off templates found there.
 func (b Builder) LoadWriteTemplates(tmplData interface{}) error {
 	sPath := filepath.Join(b.s.TemplatePath, b.kind)
-	tPath := filepath.Join(b.s.BuildPath, b.kind, b.p.ID)
+	tPath := b.Path()
 
 	if _, err := os.Stat(sPath); os.IsNotExist(err) {
 		return fmt.Errorf("failed to prepare loading templates from non-existent path %s: %s", sPath, err)
@@ -1,5 +1,7 @@
 module github.com/atelierdisko/hoi
 
+go 1.24
+
 require (
 	github.com/coreos/go-semver v0.2.0
 	github.com/coreos/go-systemd v0.0.0-20180511133405-39ca1b05acc7
@@ -8,6 +10,7 @@ require (
 	github.com/godbus/dbus v4.1.0+incompatible // indirect
 	github.com/hashicorp/hcl v0.0.0-20180404174102-ef8a98b0bbce
 	github.com/jawher/mow.cli v1.0.4
+	github.com/lib/pq v1.0.0
 	github.com/stretchr/testify v1.3.0 // indirect
 	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
@@ -45,15 +45,27 @@ func projectDirectory(path string) string {
 		}
 		path, err := filepath.Abs(path + "/..")
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: %s\n", "not able to detect project directory")
-			os.Exit(1)
+			fail("not able to detect project directory", nil)
 		}
 		return path
 	}
-	log.Fatal("not able to detect project directory")
+	fail("not able to detect project directory", nil)
 	return ""
 }
 
+// Retrieves the live status of the project's units. Failing to do so
+// is not fatal, as the status of the project itself can still be
+// shown.
+func queryUnits(path string) []sRPC.UnitReport {
+	args := &sRPC.ProjectAPIArgs{Path: path}
+	var reply []sRPC.UnitReport
+
+	if err := RPCClient.Call("Project.Units", args, &reply); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to query units, got error: %s\n", err)
+	}
+	return reply
+}
+
 func main() {
 	log.SetFlags(0) // disable prefix, we are invoked directly.
 
@@ -61,7 +73,7 @@ func main() {
 
 	// Overload commands to operate on a single (default) or multiple
 	// (all) projects.
-	App.Spec = "[--project | --all]"
+	App.Spec = "[--project | --all] [--format]"
 
 	// TODO: Move into commands?
 	path := App.String(cli.StringOpt{
@@ -73,86 +85,132 @@ func main() {
 		Name: "all",
 		Desc: "operate on all projects",
 	})
+	format := App.String(cli.StringOpt{
+		Name:  "format",
+		Desc:  "output format, either _text_, _json_ or a Go template",
+		Value: FormatText,
+	})
 
 	App.Before = func() {
+		if err := setFormat(*format); err != nil {
+			fail("failed", err)
+		}
 		client, err := rpc.Dial("unix", SocketPath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed, got error: %s\n", err)
-			os.Exit(1)
+			fail("failed", err)
 		}
 		RPCClient = client // Assign to global.
 	}
 
 	App.Command("status", "show status", func(cmd *cli.Cmd) {
+		showSecrets := cmd.Bool(cli.BoolOpt{
+			Name: "show-secrets",
+			Desc: "show plaintext passwords instead of masking them, requires root",
+		})
+
 		cmd.Action = func() {
+			if *showSecrets && os.Geteuid() != 0 {
+				fail("showing secrets requires root privileges", nil)
+			}
 
 			if *all {
-				args := &sRPC.ProjectAPIArgs{}
+				args := &sRPC.StatusAPIArgs{ShowSecrets: *showSecrets}
 				var reply []store.Entity
 
 				if err := RPCClient.Call("Project.StatusAll", args, &reply); err != nil {
-					fmt.Fprintf(os.Stderr, "failed, got error: %s\n", err)
-					os.Exit(1)
+					fail("failed", err)
 				}
-				if len(reply) <= 0 {
-					fmt.Println("no projects loaded, yet")
-					os.Exit(0)
-				}
-				fmt.Printf("%d total project/s loaded\n\n", len(reply))
-
+				statuses := make([]statusOutput, 0, len(reply))
 				for _, e := range reply {
-					printProject(e)
-					fmt.Print("\n")
+					statuses = append(statuses, statusOutput{e, queryUnits(e.Project.Path)})
 				}
+
+				output(statuses, func() {
+					if len(statuses) <= 0 {
+						fmt.Println("no projects loaded, yet")
+						return
+					}
+					fmt.Printf("%d total project/s loaded\n\n", len(statuses))
+
+					for _, s := range statuses {
+						printProject(s.Entity, s.Units)
+						fmt.Print("\n")
+					}
+				})
 				return
 			}
-			args := &sRPC.ProjectAPIArgs{
-				Path: projectDirectory(*path),
+			args := &sRPC.StatusAPIArgs{
+				Path:        projectDirectory(*path),
+				ShowSecrets: *showSecrets,
 			}
 			var reply store.Entity
 			if err := RPCClient.Call("Project.Status", args, &reply); err != nil {
-				fmt.Fprintf(os.Stderr, "failed, got error: %s\n", err)
-				os.Exit(1)
+				fail("failed", err)
 			}
-			printProject(reply)
+			status := statusOutput{reply, queryUnits(args.Path)}
+
+			output(status, func() {
+				printProject(status.Entity, status.Units)
+			})
 		}
 	})
 
 	App.Command("load", "loads project configuration", func(cmd *cli.Cmd) {
+		dryRun := cmd.Bool(cli.BoolOpt{
+			Name: "dry-run",
+			Desc: "show what would change, without changing anything",
+		})
+
 		cmd.Action = func() {
 			args := &sRPC.ProjectAPIArgs{
 				Path: projectDirectory(*path),
 			}
+			if *dryRun {
+				printPlan(args)
+				return
+			}
 			var reply bool
 
 			if err := RPCClient.Call("Project.Load", args, &reply); err != nil {
-				fmt.Fprintf(os.Stderr, "failed, got error: %s\n", err)
-				os.Exit(1)
+				fail("failed", err)
 			}
-			fmt.Println("project successfully loaded :)")
+			success("load", args.Path, "project successfully loaded :)")
 		}
 	})
 
 	App.Command("reload", "reloads project configuration", func(cmd *cli.Cmd) {
+		dryRun := cmd.Bool(cli.BoolOpt{
+			Name: "dry-run",
+			Desc: "show what would change, without changing anything",
+		})
+
 		cmd.Action = func() {
 			var reply bool
 
+			if *dryRun {
+				if *all {
+					fail("dry-run for all projects is not supported", nil)
+				}
+				printPlan(&sRPC.ProjectAPIArgs{
+					Path: projectDirectory(*path),
+				})
+				return
+			}
+
 			if *all {
 				args := &sRPC.ProjectAPIArgs{}
 				if err := RPCClient.Call("Project.ReloadAll", args, &reply); err != nil {
-					fmt.Fprintf(os.Stderr, "failed reloading, got error: %s\n", err)
-					os.Exit(1)
+					fail("failed reloading", err)
 				}
-				fmt.Println("all projects successfully reloaded :)")
+				success("reload", "", "all projects successfully reloaded :)")
 			} else {
 				args := &sRPC.ProjectAPIArgs{
 					Path: projectDirectory(*path),
 				}
 				if err := RPCClient.Call("Project.Reload", args, &reply); err != nil {
-					fmt.Fprintf(os.Stderr, "failed reloading, got error: %s\n", err)
-					os.Exit(1)
+					fail("failed reloading", err)
 				}
-				fmt.Println("project successfully reloaded :)")
+				success("reload", args.Path, "project successfully reloaded :)")
 			}
 		}
 	})
@@ -164,19 +222,17 @@ func main() {
 			if *all {
 				args := &sRPC.ProjectAPIArgs{}
 				if err := RPCClient.Call("Project.UnloadAll", args, &reply); err != nil {
-					fmt.Fprintf(os.Stderr, "failed unloading, got error: %s\n", err)
-					os.Exit(1)
+					fail("failed unloading", err)
 				}
-				fmt.Println("all projects successfully unloaded :(")
+				success("unload", "", "all projects successfully unloaded :(")
 			} else {
 				args := &sRPC.ProjectAPIArgs{
 					Path: projectDirectory(*path),
 				}
 				if err := RPCClient.Call("Project.Unload", args, &reply); err != nil {
-					fmt.Fprintf(os.Stderr, "failed unloading, got error: %s\n", err)
-					os.Exit(1)
+					fail("failed unloading", err)
 				}
-				fmt.Println("project successfully unloaded :(")
+				success("unload", args.Path, "project successfully unloaded :(")
 			}
 		}
 	})
@@ -206,10 +262,9 @@ func main() {
 			var reply bool
 
 			if err := RPCClient.Call("Project.Domain", args, &reply); err != nil {
-				fmt.Fprintf(os.Stderr, "failed, got error: %s\n", err)
-				os.Exit(1)
+				fail("failed", err)
 			}
-			fmt.Println("domain added/modified in project")
+			success("domain", args.Path, "domain added/modified in project")
 		}
 	})
 
@@ -220,8 +275,7 @@ func main() {
 		if !filepath.IsAbs(*targetArg) {
 			wd, err := os.Getwd()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to get current working directory: %s\n", err)
-				os.Exit(1)
+				fail("failed to get current working directory", err)
 			}
 			target = filepath.Join(wd, *targetArg)
 		} else {
@@ -232,8 +286,7 @@ func main() {
 			var reply bool
 
 			if *all {
-				fmt.Fprint(os.Stderr, "dumping all projects is not supported")
-				os.Exit(1)
+				fail("dumping all projects is not supported", nil)
 			}
 
 			args := &sRPC.DumpAPIArgs{
@@ -241,10 +294,74 @@ func main() {
 				File: target,
 			}
 			if err := RPCClient.Call("Project.Dump", args, &reply); err != nil {
-				fmt.Fprintf(os.Stderr, "failed dumping, got error: %s\n", err)
-				os.Exit(1)
+				fail("failed dumping", err)
+			}
+			success("dump", args.Path, fmt.Sprintf("project successfully dumped: %s created", target))
+		}
+	})
+
+	App.Command("restore", "imports databases and persistent volumes from a dump", func(cmd *cli.Cmd) {
+		sourceArg := cmd.StringArg("FILE", "", "The name and path of the dump to restore from.")
+
+		cmd.Action = func() {
+			var reply bool
+
+			if *all {
+				fail("restoring all projects is not supported", nil)
+			}
+
+			source, err := filepath.Abs(*sourceArg)
+			if err != nil {
+				fail("failed to get absolute path to dump", err)
+			}
+			if _, err := os.Stat(source); err != nil {
+				fail("failed to access dump", err)
+			}
+
+			args := &sRPC.DumpAPIArgs{
+				Path: projectDirectory(*path),
+				File: source,
+			}
+			if err := RPCClient.Call("Project.Restore", args, &reply); err != nil {
+				fail("failed restoring", err)
+			}
+			success("restore", args.Path, fmt.Sprintf("project successfully restored from: %s", source))
+		}
+	})
+
+	App.Command("certs", "show installed SSL certificates", func(cmd *cli.Cmd) {
+		cmd.Action = func() {
+			var reply []sRPC.CertStatus
+
+			if *all {
+				args := &sRPC.ProjectAPIArgs{}
+
+				if err := RPCClient.Call("Project.CertsAll", args, &reply); err != nil {
+					fail("failed", err)
+				}
+			} else {
+				args := &sRPC.ProjectAPIArgs{
+					Path: projectDirectory(*path),
+				}
+				if err := RPCClient.Call("Project.Certs", args, &reply); err != nil {
+					fail("failed", err)
+				}
+			}
+			output(reply, func() {
+				if len(reply) <= 0 {
+					fmt.Println("no SSL certificates installed")
+					return
+				}
+				printCerts(reply)
+			})
+
+			// Allows monitoring to alert on certificates with
+			// problems, the output has been given already.
+			for _, c := range reply {
+				if c.HasProblems() {
+					os.Exit(1)
+				}
 			}
-			fmt.Printf("project successfully dumped: %s created\n", target)
 		}
 	})
 
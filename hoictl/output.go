@@ -0,0 +1,125 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/template"
+
+	sRPC "github.com/atelierdisko/hoi/rpc"
+	"github.com/atelierdisko/hoi/store"
+)
+
+// Output formats; any other format is used as a Go template.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var (
+	// Format as given via the --format option.
+	Format = FormatText
+	// Parsed template, when Format is neither text nor JSON.
+	FormatTemplate *template.Template
+)
+
+// Status of a single project, as output by the status command.
+type statusOutput struct {
+	store.Entity
+	// Live status of the project's units.
+	Units []sRPC.UnitReport
+}
+
+// Result of a command performing an action, i.e. load.
+type actionOutput struct {
+	// Name of the command, i.e. "load".
+	Action string
+	// Absolute path to the project; empty when operating on all
+	// projects.
+	Project string `json:",omitempty"`
+	Message string
+}
+
+// Result of a dry-run.
+type planOutput struct {
+	Project string
+	// Changes as a unified diff.
+	Plan string
+}
+
+type errorOutput struct {
+	Message string
+	Error   string `json:",omitempty"`
+}
+
+// Validates and sets the output format.
+func setFormat(format string) error {
+	Format = format
+
+	if format == FormatText || format == FormatJSON {
+		return nil
+	}
+	t, err := template.New("format").Parse(format)
+	if err != nil {
+		Format = FormatText
+		return fmt.Errorf("failed to parse format template: %s", err)
+	}
+	FormatTemplate = t
+	return nil
+}
+
+// Outputs data in the selected format. Uses the text function to
+// generate human readable output.
+func output(data interface{}, text func()) {
+	switch Format {
+	case FormatText:
+		text()
+	case FormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(data); err != nil {
+			fail("failed to encode output", err)
+		}
+	default:
+		if err := FormatTemplate.Execute(os.Stdout, data); err != nil {
+			fail("failed to render output", err)
+		}
+		fmt.Print("\n")
+	}
+}
+
+// Reports the successful completion of an action.
+func success(action string, project string, message string) {
+	data := actionOutput{Action: action, Project: project, Message: message}
+
+	output(data, func() {
+		fmt.Println(message)
+	})
+}
+
+// Reports an error and exits with a non-zero code. The error is
+// optional. In JSON format the error is written to stdout, so
+// all output can be parsed the same way. Templates are not used
+// for errors.
+func fail(message string, err error) {
+	if Format == FormatJSON {
+		data := errorOutput{Message: message}
+		if err != nil {
+			data.Error = err.Error()
+		}
+		json.NewEncoder(os.Stdout).Encode(data)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s, got error: %s\n", message, err)
+	} else {
+		fmt.Fprintln(os.Stderr, message)
+	}
+	os.Exit(1)
+}
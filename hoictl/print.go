@@ -7,10 +7,63 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/atelierdisko/hoi/project"
+	sRPC "github.com/atelierdisko/hoi/rpc"
 	"github.com/atelierdisko/hoi/store"
+	"github.com/atelierdisko/hoi/system"
 )
 
+// Retrieves and outputs the changes (re)loading a project would cause.
+func printPlan(args *sRPC.ProjectAPIArgs) {
+	var reply string
+
+	if err := RPCClient.Call("Project.Plan", args, &reply); err != nil {
+		fail("failed planning", err)
+	}
+	output(planOutput{Project: args.Path, Plan: reply}, func() {
+		fmt.Print(reply)
+	})
+}
+
+// Outputs a table of certificates, flagging those that expire soon or
+// don't cover all names their domain is served under.
+func printCerts(certs []sRPC.CertStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROJECT\tDOMAIN\tISSUER\tEXPIRES\tSTATE")
+
+	for _, c := range certs {
+		if c.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t%s\n", c.ProjectName, c.FQDN, c.Error)
+			continue
+		}
+		state := make([]string, 0)
+		if c.Expiring {
+			state = append(state, "EXPIRING")
+		}
+		if len(c.Uncovered) > 0 {
+			state = append(state, "NOT COVERING "+strings.Join(c.Uncovered, ", "))
+		}
+		if len(state) == 0 {
+			state = append(state, "ok")
+		}
+		fmt.Fprintf(
+			w, "%s\t%s\t%s\t%s\t%s\n",
+			c.ProjectName,
+			c.FQDN,
+			c.Issuer,
+			c.NotAfter.Local().Format("2006-01-02 15:04"),
+			strings.Join(state, "; "),
+		)
+	}
+	w.Flush()
+}
+
 // Outputs information about a project entity.
 //
 // Roughly modelled aftret the systemctl status output:
@@ -30,13 +83,41 @@ import (
 //              ├─15326 nginx: worker process
 //              └─15327 nginx: worker process
 //
+// Live status of units is printed next to the directive they belong
+// to, when units are given.
+//
 // FIXME: Use go text template for generating output.
-func printProject(e store.Entity) {
+func printProject(e store.Entity, units []sRPC.UnitReport) {
 	fmt.Printf("● %-20s\n", e.Project.PrettyName())
 	fmt.Printf(" %14s: %s\n", "ID", e.Project.ID)
 	fmt.Printf(" %14s: **%s**\n", "Status", e.Meta.Status)
+	if e.Meta.Error != "" {
+		fmt.Printf(" %14s: %s\n", "Error", e.Meta.Error)
+	}
+	if e.Meta.RollbackError != "" {
+		fmt.Printf(" %14s: %s\n", "Rollback Error", e.Meta.RollbackError)
+	}
+	if len(e.Meta.Health) > 0 {
+		checks := make([]string, 0, len(e.Meta.Health))
+		for name := range e.Meta.Health {
+			checks = append(checks, name)
+		}
+		sort.Strings(checks)
+
+		for _, name := range checks {
+			h := e.Meta.Health[name]
+			state := "passing"
+			if h.Error != "" {
+				state = "failing (" + h.Error + ")"
+			}
+			fmt.Printf(" %14s: %s: %s, checked %s\n", "Health", name, state, h.Checked.Local().Format("2006-01-02 15:04:05"))
+		}
+	}
 	fmt.Printf(" %14s: %s\n", "Path", e.Project.Path)
 	fmt.Printf(" %14s: %d\n", "Format Version", e.Project.FormatVersion)
+	for _, u := range filterUnits(units, system.SystemdKindSlice, "") {
+		fmt.Printf(" %14s: %s\n", "Slice", formatUnit(u))
+	}
 
 	fmt.Print("\n")
 
@@ -44,11 +125,25 @@ func printProject(e store.Entity) {
 	if e.Project.App.Version != "" {
 		fmt.Printf("          - %s: %s\n", "Version", e.Project.App.Version)
 	}
+	if e.Meta.Runtime != "" {
+		fmt.Printf("          - %s: %s\n", "Runtime", e.Meta.Runtime)
+	}
 	if e.Project.App.HasCommand() {
 		fmt.Printf("          - %s: %s\n", "Command", e.Project.App.Command)
 	}
-	if e.Project.App.Host != "" || e.Project.App.Port != 0 {
-		fmt.Printf("          - %s: %s:%d\n", "Address", e.Project.App.Host, e.Project.App.Port)
+	if e.Project.App.UsesSocket() {
+		fmt.Printf("          - %s: %s\n", "Listen", "unix socket")
+	}
+	for _, port := range e.Project.App.GetPorts() {
+		fmt.Printf("          - %s: %s:%d\n", "Address", e.Project.App.Host, port)
+	}
+	if e.Project.App.IsUpstream() {
+		for _, u := range e.Project.Proxy.URLs {
+			fmt.Printf("          - %s: %s\n", "Upstream", u)
+		}
+	}
+	for _, u := range filterUnits(units, system.SystemdKindAppService, "") {
+		fmt.Printf("          - %s: %s: %s\n", "Unit", u.Status.Unit, formatUnit(u))
 	}
 
 	if len(e.Project.Domain) > 0 {
@@ -76,10 +171,27 @@ func printProject(e store.Entity) {
 		}
 	}
 
+	if len(e.Project.Location) > 0 {
+		fmt.Printf(" %8s: %d\n", "Location", len(e.Project.Location))
+		for _, l := range e.Project.GetLocations() {
+			if l.Kind == project.AppKindService {
+				fmt.Printf("          - %s (%s, %s:%d)\n", l.Path, l.Kind, l.Host, l.Port)
+			} else {
+				fmt.Printf("          - %s (%s, %s)\n", l.Path, l.Kind, l.Root)
+			}
+			for _, u := range filterUnits(units, system.SystemdKindLocation, l.Path) {
+				fmt.Printf("            %s\n", formatUnit(u))
+			}
+		}
+	}
+
 	if len(e.Project.Cron) > 0 {
 		fmt.Printf(" %8s: %d\n", "Cron", len(e.Project.Cron))
 		for _, c := range e.Project.Cron {
 			fmt.Printf("          - %s\n", c.Name)
+			for _, u := range filterUnits(units, system.SystemdKindCron, c.GetID()) {
+				fmt.Printf("            %s\n", formatUnit(u))
+			}
 		}
 	}
 
@@ -87,6 +199,9 @@ func printProject(e store.Entity) {
 		fmt.Printf(" %8s: %d\n", "Worker", len(e.Project.Worker))
 		for _, w := range e.Project.Worker {
 			fmt.Printf("          - %s (x%d)\n", w.Name, w.Instances)
+			for _, u := range filterUnits(units, system.SystemdKindWorker, w.GetID()) {
+				fmt.Printf("            %s: %s\n", u.Status.Unit, formatUnit(u))
+			}
 		}
 	}
 
@@ -111,6 +226,70 @@ func printProject(e store.Entity) {
 			} else {
 				fmt.Printf("          P %s\n", v.Path)
 			}
+			for _, u := range filterUnits(units, system.SystemdKindVolume, v.Path) {
+				fmt.Printf("            %s\n", formatUnit(u))
+			}
+		}
+	}
+}
+
+func filterUnits(units []sRPC.UnitReport, kind string, directive string) []sRPC.UnitReport {
+	filtered := make([]sRPC.UnitReport, 0)
+
+	for _, u := range units {
+		if u.Kind == kind && u.Directive == directive {
+			filtered = append(filtered, u)
+		}
+	}
+	return filtered
+}
+
+// Formats unit status similar to systemctl, i.e.
+// "active (running) since ..., PID 1693, 12.4M memory".
+func formatUnit(u sRPC.UnitReport) string {
+	if u.Error != "" {
+		return fmt.Sprintf("unknown (%s)", u.Error)
+	}
+	parts := []string{fmt.Sprintf("%s (%s)", u.Status.ActiveState, u.Status.SubState)}
+
+	if u.Status.MainPID != 0 {
+		parts = append(parts, fmt.Sprintf("PID %d", u.Status.MainPID))
+	}
+	if u.Status.Memory != 0 {
+		parts = append(parts, fmt.Sprintf("%s memory", formatBytes(u.Status.Memory)))
+	}
+	if u.Status.CPUUsage != 0 {
+		parts = append(parts, fmt.Sprintf("%s CPU", u.Status.CPUUsage.Round(time.Millisecond)))
+	}
+	if u.Status.Restarts != 0 {
+		parts = append(parts, fmt.Sprintf("%d restarts", u.Status.Restarts))
+	}
+	if u.Kind == system.SystemdKindCron {
+		last, next := "never", "-"
+		if !u.Status.LastTrigger.IsZero() {
+			last = u.Status.LastTrigger.Local().Format("2006-01-02 15:04")
+		}
+		if !u.Status.NextTrigger.IsZero() {
+			next = u.Status.NextTrigger.Local().Format("2006-01-02 15:04")
 		}
+		parts = append(parts, "last "+last, "next "+next)
+	}
+	if u.Status.Result != "" && (u.Status.Result != "success" || u.Kind == system.SystemdKindCron) {
+		parts = append(parts, "result "+u.Status.Result)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Formats bytes using binary units, as systemctl does.
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
 	}
+	return fmt.Sprintf("%.1f%c", float64(b)/float64(div), "KMGTPE"[exp])
 }
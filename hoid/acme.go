@@ -0,0 +1,69 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/atelierdisko/hoi/project"
+	"github.com/atelierdisko/hoi/runner"
+	"github.com/atelierdisko/hoi/system"
+)
+
+// Signals the ACME loop to check certificates right away, i.e.
+// after a project has been loaded. Buffered, so that a pending
+// check is not lost while the loop is busy.
+var acmeTrigger = make(chan struct{}, 1)
+
+// Requests a check of ACME certificates without blocking; a no-op
+// if a check is already pending.
+func triggerACME() {
+	select {
+	case acmeTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// Periodically obtains and renews certificates via ACME for all
+// active projects. Runs until the process exits.
+func runACME(interval time.Duration) {
+	log.Printf("checking ACME certificates every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		renewCertificates()
+
+		select {
+		case <-ticker.C:
+		case <-acmeTrigger:
+		}
+	}
+}
+
+// Uses a single ACME client for all projects, so the account is
+// registered once per run.
+func renewCertificates() {
+	acme, err := system.NewACME(Config)
+	if err != nil {
+		log.Printf("failed to set up ACME client: %s", err)
+		return
+	}
+	for _, e := range Store.ReadAll() {
+		switch e.Meta.Status {
+		case project.StatusActive, project.StatusRolledBack, project.StatusDegraded:
+		default:
+			continue
+		}
+		r := runner.NewWebRunner(Config, e.Project, SystemdConn)
+
+		if err := r.RenewCertificates(acme); err != nil {
+			log.Printf("failed to renew ACME certificates of project %s: %s", e.Project.PrettyName(), err)
+		}
+	}
+}
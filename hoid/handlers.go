@@ -7,66 +7,257 @@ package main
 
 import (
 	"archive/tar"
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"reflect"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/atelierdisko/hoi/project"
+	"github.com/atelierdisko/hoi/rpc"
 	"github.com/atelierdisko/hoi/runner"
+	"github.com/atelierdisko/hoi/secret"
 	"github.com/atelierdisko/hoi/store"
+	"github.com/atelierdisko/hoi/system"
 )
 
-func handleStatus(path string) (store.Entity, error) {
-	return Store.Read(project.PathToID(path))
+// Plaintext secrets inside the project configuration are masked,
+// unless showSecrets is given.
+func handleStatus(path string, showSecrets bool) (store.Entity, error) {
+	e, err := Store.Read(project.PathToID(path))
+	if err != nil {
+		return e, err
+	}
+	e = withRuntime(e)
+	if !showSecrets {
+		e.Project = secret.RedactConfig(e.Project)
+	}
+	return e, nil
 }
 
-func handleStatusAll() ([]store.Entity, error) {
-	return Store.ReadAll(), nil
+func handleStatusAll(showSecrets bool) ([]store.Entity, error) {
+	es := Store.ReadAll()
+	for i := range es {
+		es[i] = withRuntime(es[i])
+		if !showSecrets {
+			es[i].Project = secret.RedactConfig(es[i].Project)
+		}
+	}
+	return es, nil
 }
 
-func handleLoad(path string) error {
-	log.Printf("loading project from: %s", path)
+// Adds the runtime the app runs on to a copy of the entity's meta,
+// leaving the stored meta untouched.
+func withRuntime(e store.Entity) store.Entity {
+	m := *e.Meta
+	m.Runtime = e.Project.App.DescribeRuntime(Config)
+	e.Meta = &m
+	return e
+}
+
+// Queries systemd for the live status of all units installed for the
+// project.
+func handleUnits(path string) ([]rpc.UnitReport, error) {
+	id := project.PathToID(path)
+
+	if !Store.Has(id) {
+		return nil, fmt.Errorf("no project %s in store", id)
+	}
+	e, _ := Store.Read(id)
+
+	reports := make([]rpc.UnitReport, 0)
+	for _, r := range runners(e.Project) {
+		reporter, ok := r.(runner.Reporter)
+		if !ok {
+			continue
+		}
+		rs, err := reporter.Status()
+		if err != nil {
+			return reports, fmt.Errorf("failed to query status of project %s: %s", e.Project.PrettyName(), err)
+		}
+		for _, report := range rs {
+			reports = append(reports, rpc.UnitReport{
+				Kind:      report.Kind,
+				Directive: report.Directive,
+				Status:    rpc.UnitStatus(report.Status),
+				Error:     report.Error,
+			})
+		}
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		if reports[i].Kind != reports[j].Kind {
+			return reports[i].Kind < reports[j].Kind
+		}
+		if reports[i].Directive != reports[j].Directive {
+			return reports[i].Directive < reports[j].Directive
+		}
+		return reports[i].Status.Unit < reports[j].Status.Unit
+	})
+	return reports, nil
+}
+
+func handleCerts(path string) ([]rpc.CertStatus, error) {
+	e, err := Store.Read(project.PathToID(path))
+	if err != nil {
+		return nil, err
+	}
+	return certStatuses(e.Project)
+}
+
+func handleCertsAll() ([]rpc.CertStatus, error) {
+	statuses := make([]rpc.CertStatus, 0)
+
+	for _, e := range Store.ReadAll() {
+		s, err := certStatuses(e.Project)
+		if err != nil {
+			return statuses, err
+		}
+		statuses = append(statuses, s...)
+	}
+	return statuses, nil
+}
 
+// Inspects the installed certificates of each SSL enabled domain of
+// the project and flags those which expire soon or don't cover all
+// names the domain is served under.
+func certStatuses(pCfg *project.Config) ([]rpc.CertStatus, error) {
+	statuses := make([]rpc.CertStatus, 0)
+
+	window, err := Config.SSL.GetExpiryWarning()
+	if err != nil {
+		return statuses, fmt.Errorf("failed to parse SSL expiry warning: %s", err)
+	}
+	ssl := system.NewSSL(pCfg, Config)
+
+	for _, d := range pCfg.Domain {
+		if !d.SSL.IsEnabled() {
+			continue
+		}
+		status := rpc.CertStatus{
+			ProjectID:   pCfg.ID,
+			ProjectName: pCfg.PrettyName(),
+			FQDN:        d.FQDN,
+		}
+
+		cert, err := ssl.InspectCertificate(d.FQDN)
+		if err != nil {
+			status.Error = err.Error()
+			statuses = append(statuses, status)
+			continue
+		}
+		status.Subject = cert.Subject.CommonName
+		status.Issuer = cert.Issuer.CommonName
+		status.Names = cert.DNSNames
+		status.NotAfter = cert.NotAfter
+		status.Expiring = time.Now().Add(window).After(cert.NotAfter)
+
+		for _, name := range d.GetNames() {
+			if cert.VerifyHostname(name) != nil {
+				status.Uncovered = append(status.Uncovered, name)
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].FQDN < statuses[j].FQDN
+	})
+	return statuses, nil
+}
+
+// Parses, augments and validates the Hoifile of the project at the
+// given path, against the server configuration.
+func loadProjectConfig(path string) (*project.Config, error) {
 	pCfg, err := project.NewFromFile(path + "/Hoifile")
 	if err != nil {
-		return fmt.Errorf("failed to parse Hoifile in project %s: %s", pCfg.PrettyName(), err)
+		return nil, fmt.Errorf("failed to parse Hoifile in project %s: %s", pCfg.PrettyName(), err)
 	}
 	if err = pCfg.Augment(); err != nil {
-		return fmt.Errorf("failed to augment config in project %s: %s", pCfg.PrettyName(), err)
+		return nil, fmt.Errorf("failed to augment config in project %s: %s", pCfg.PrettyName(), err)
 	}
 	if err = pCfg.Validate(); err != nil {
-		return fmt.Errorf("failed to validate config in project %s: %s", pCfg.PrettyName(), err)
+		return nil, fmt.Errorf("failed to validate config in project %s: %s", pCfg.PrettyName(), err)
 	}
+	if err = pCfg.ValidateLimits(Config); err != nil {
+		return nil, fmt.Errorf("failed to validate config in project %s: %s", pCfg.PrettyName(), err)
+	}
+	if err = pCfg.ValidateRuntime(Config); err != nil {
+		return nil, fmt.Errorf("failed to validate config in project %s: %s", pCfg.PrettyName(), err)
+	}
+	return pCfg, nil
+}
 
-	steps := make([]func() error, 0)
-	for _, r := range runners(pCfg) {
-		steps = append(
-			steps,
-			r.Disable,
-			r.Enable,
-			r.Commit,
-		)
+func handleLoad(path string) error {
+	log.Printf("loading project from: %s", path)
+
+	pCfg, err := loadProjectConfig(path)
+	if err != nil {
+		return err
 	}
 
+	rs := runners(pCfg)
+	steps := append(enableSteps(rs, true), waitSteps(rs)...)
+
+	prev := lastGoodConfig(pCfg.ID)
+
 	if err := Store.Write(pCfg.ID, pCfg); err != nil {
 		return err
 	}
 	Store.WriteStatus(pCfg.ID, project.StatusLoading)
 
 	if err := performSteps(pCfg, steps); err != nil {
-		Store.WriteStatus(pCfg.ID, project.StatusFailed)
-		return fmt.Errorf("failed to load project %s: %s", pCfg.PrettyName(), err)
+		err = fmt.Errorf("failed to load project %s: %s", pCfg.PrettyName(), err)
+		return handleFailure(pCfg, prev, err, runners)
 	}
 
 	log.Printf("project %s is now active :)", pCfg.PrettyName())
 	Store.WriteStatus(pCfg.ID, project.StatusActive)
+
+	if Config.SSL.ACME.Enabled {
+		triggerACME()
+	}
 	return nil
 }
 
+// Plans the changes loading or reloading the project would cause,
+// without applying any of them. Returns a (mostly) unified diff.
+func handlePlan(path string) (string, error) {
+	log.Printf("planning project from: %s", path)
+
+	pCfg, err := loadProjectConfig(path)
+	if err != nil {
+		return "", err
+	}
+
+	scratch, err := ioutil.TempDir("", "hoi_")
+	if err != nil {
+		return "", fmt.Errorf("failed to plan project %s: %s", pCfg.PrettyName(), err)
+	}
+	defer os.RemoveAll(scratch)
+
+	var buf bytes.Buffer
+	for _, p := range planners(pCfg) {
+		changes, err := p.Plan(scratch)
+		if err != nil {
+			return "", fmt.Errorf("failed to plan project %s: %s", pCfg.PrettyName(), err)
+		}
+		for _, c := range changes {
+			if err := renderChange(&buf, c); err != nil {
+				return "", fmt.Errorf("failed to plan project %s: %s", pCfg.PrettyName(), err)
+			}
+		}
+	}
+	return buf.String(), nil
+}
+
 func handleUnload(path string) error {
 	id := project.PathToID(path)
 
@@ -102,28 +293,47 @@ func handleUnload(path string) error {
 	return nil
 }
 
+// Unloads all projects; up to Config.GetConcurrency() projects are
+// disabled at the same time. Services are reloaded once, after all
+// projects have been disabled. A failing project doesn't stop the
+// others from being unloaded.
 func handleUnloadAll() error {
-	for _, e := range Store.ReadAll() {
+	es := Store.ReadAll()
+	disabled := make([]*project.Config, len(es))
+
+	errs := forEachProject(es, func(i int, e store.Entity) error {
 		Store.WriteStatus(e.Project.ID, project.StatusUnloading)
 
 		steps := make([]func() error, 0)
 		for _, r := range runners(e.Project) {
-			steps = append(
-				steps,
-				r.Disable,
-				r.Commit,
-			)
+			steps = append(steps, r.Disable)
 		}
 		if err := performSteps(e.Project, steps); err != nil {
 			Store.WriteStatus(e.Project.ID, project.StatusFailed)
 			return fmt.Errorf("failed to unload project %s: %s", e.Project.PrettyName(), err)
 		}
-		if err := Store.Delete(e.Project.ID); err != nil {
-			Store.WriteStatus(e.Project.ID, project.StatusFailed)
-			return err
+		disabled[i] = e.Project
+		return nil
+	})
+
+	for i, pCfg := range disabled {
+		if pCfg == nil {
+			continue
+		}
+		if err := performSteps(pCfg, commitSteps(pCfg)); err != nil {
+			Store.WriteStatus(pCfg.ID, project.StatusFailed)
+			errs[i] = fmt.Errorf("failed to unload project %s: %s", pCfg.PrettyName(), err)
+			continue
+		}
+		if err := Store.Delete(pCfg.ID); err != nil {
+			Store.WriteStatus(pCfg.ID, project.StatusFailed)
+			errs[i] = err
 		}
 	}
 
+	if err := collectErrors("unload", errs); err != nil {
+		return err
+	}
 	log.Printf("all projects unloaded :(")
 	return nil
 }
@@ -140,26 +350,15 @@ func handleReload(path string) error {
 		return fmt.Errorf("failed to read project at path %s from store: %s", path, err)
 	}
 
-	pCfg, err := project.NewFromFile(e.Project.Path + "/Hoifile")
+	pCfg, err := loadProjectConfig(e.Project.Path)
 	if err != nil {
-		return fmt.Errorf("failed to parse Hoifile in project %s: %s", pCfg.PrettyName(), err)
-	}
-	if err = pCfg.Augment(); err != nil {
-		return fmt.Errorf("failed to augment config in project %s: %s", pCfg.PrettyName(), err)
-	}
-	if err = pCfg.Validate(); err != nil {
-		return fmt.Errorf("failed to validate config in project %s: %s", pCfg.PrettyName(), err)
+		return err
 	}
 
-	steps := make([]func() error, 0)
-	for _, r := range runners(pCfg) {
-		steps = append(
-			steps,
-			r.Disable,
-			r.Enable,
-			r.Commit,
-		)
-	}
+	rs := runners(pCfg)
+	steps := append(enableSteps(rs, true), waitSteps(rs)...)
+
+	prev := lastGoodConfig(pCfg.ID)
 
 	if err := Store.Write(pCfg.ID, pCfg); err != nil {
 		return err
@@ -167,48 +366,92 @@ func handleReload(path string) error {
 	Store.WriteStatus(pCfg.ID, project.StatusReloading)
 
 	if err := performSteps(pCfg, steps); err != nil {
-		Store.WriteStatus(pCfg.ID, project.StatusFailed)
-		return fmt.Errorf("failed to reload project %s: %s", pCfg.PrettyName(), err)
+		err = fmt.Errorf("failed to reload project %s: %s", pCfg.PrettyName(), err)
+		return handleFailure(pCfg, prev, err, runners)
 	}
 
 	log.Printf("project %s reloaded", pCfg.PrettyName())
 	Store.WriteStatus(pCfg.ID, project.StatusActive)
+
+	if Config.SSL.ACME.Enabled {
+		triggerACME()
+	}
 	return nil
 }
 
+// Reloads all projects; up to Config.GetConcurrency() projects are
+// reloaded at the same time. Services are reloaded once, after all
+// projects have been enabled. Failing projects are rolled back
+// individually, without stopping the others from being reloaded.
 func handleReloadAll() error {
-	for _, e := range Store.ReadAll() {
-		pCfg, err := project.NewFromFile(e.Project.Path + "/Hoifile")
+	es := Store.ReadAll()
+	enabled := make([]*project.Config, len(es))
+	failed := make([]*project.Config, len(es))
+	prevs := make([]*project.Config, len(es))
+
+	errs := forEachProject(es, func(i int, e store.Entity) error {
+		pCfg, err := loadProjectConfig(e.Project.Path)
 		if err != nil {
-			return fmt.Errorf("failed to parse Hoifile in project %s: %s", pCfg.PrettyName(), err)
-		}
-		if err = pCfg.Augment(); err != nil {
-			return fmt.Errorf("failed to augment config in project %s: %s", pCfg.PrettyName(), err)
-		}
-		if err = pCfg.Validate(); err != nil {
-			return fmt.Errorf("failed to validate config in project %s: %s", pCfg.PrettyName(), err)
+			return err
 		}
+		prevs[i] = lastGoodConfig(pCfg.ID)
+
 		if err := Store.Write(pCfg.ID, pCfg); err != nil {
 			return err
 		}
 		Store.WriteStatus(pCfg.ID, project.StatusReloading)
 
-		steps := make([]func() error, 0)
-		for _, r := range runners(pCfg) {
-			steps = append(
-				steps,
-				r.Disable,
-				r.Enable,
-				r.Commit,
-			)
+		if err := performSteps(pCfg, enableSteps(runners(pCfg), false)); err != nil {
+			failed[i] = pCfg
+			return fmt.Errorf("failed to reload project %s: %s", pCfg.PrettyName(), err)
+		}
+		enabled[i] = pCfg
+		return nil
+	})
+
+	// Rolling back commits, which must not happen while other
+	// projects are still being enabled: their half-built
+	// configuration would be committed along.
+	for i, pCfg := range failed {
+		if pCfg != nil {
+			errs[i] = handleFailure(pCfg, prevs[i], errs[i], runners)
 		}
+	}
+
+	// The first commit reloads services for all projects, the ones
+	// that follow are usually no-ops. When committing fails, the
+	// project causing it can't be told from the others, i.e. a single
+	// invalid NGINX server configuration makes reloading NGINX fail
+	// for all: the remaining projects are committed one by one.
+	for i, pCfg := range enabled {
+		if pCfg == nil {
+			continue
+		}
+		if err := performSteps(pCfg, commitSteps(pCfg)); err != nil {
+			log.Printf("failed to commit project %s, committing remaining projects one by one: %s", pCfg.PrettyName(), err)
+
+			for j, err := range commitIsolated(enabled[i:], prevs[i:]) {
+				errs[i+j] = err
+			}
+			break
+		}
+		rs := runners(pCfg)
+		steps := append(retireSteps(rs), waitSteps(rs)...)
+
 		if err := performSteps(pCfg, steps); err != nil {
-			Store.WriteStatus(pCfg.ID, project.StatusFailed)
-			return fmt.Errorf("failed to reload project %s: %s", pCfg.PrettyName(), err)
+			err = fmt.Errorf("failed to reload project %s: %s", pCfg.PrettyName(), err)
+			errs[i] = handleFailure(pCfg, prevs[i], err, runners)
+			continue
 		}
 		Store.WriteStatus(pCfg.ID, project.StatusActive)
 	}
 
+	if Config.SSL.ACME.Enabled {
+		triggerACME()
+	}
+	if err := collectErrors("reload", errs); err != nil {
+		return err
+	}
 	log.Printf("all projects reloaded")
 	return nil
 }
@@ -220,30 +463,32 @@ func handleDomain(path string, dDrv *project.DomainDirective) error {
 		return fmt.Errorf("no project %s in store", id)
 	}
 	e, _ := Store.Read(id)
+	prev := lastGoodConfig(id)
+
+	// Work on a copy of the stored configuration, so we can still
+	// roll back to it, if applying the modified one fails.
+	pCfg := *e.Project
+	pCfg.Domain = make(map[string]project.DomainDirective, len(e.Project.Domain)+1)
+	for k, v := range e.Project.Domain {
+		pCfg.Domain[k] = v
+	}
 
-	if _, hasKey := e.Project.Domain[dDrv.FQDN]; hasKey {
-		el := e.Project.Domain[dDrv.FQDN]
+	if _, hasKey := pCfg.Domain[dDrv.FQDN]; hasKey {
+		el := pCfg.Domain[dDrv.FQDN]
 		el.AddAliases(dDrv.Aliases...)
 		el.WWW = dDrv.WWW
 
-		e.Project.Domain[dDrv.FQDN] = el
+		pCfg.Domain[dDrv.FQDN] = el
 	} else {
-		e.Project.Domain[dDrv.FQDN] = *dDrv
+		pCfg.Domain[dDrv.FQDN] = *dDrv
 	}
 
-	if err := e.Project.Validate(); err != nil {
-		return fmt.Errorf("failed adding/modifying domain %s for project %s, config did not validate: %s", dDrv.FQDN, e.Project.PrettyName(), err)
-	}
-
-	// Save us iterating through all runners, when the only one
-	// needed for domain updates is the web runner.
-	runners := make([]runner.Runnable, 0)
-	if Config.Web.Enabled {
-		runners = append(runners, runner.NewWebRunner(Config, e.Project, SystemdConn))
+	if err := pCfg.Validate(); err != nil {
+		return fmt.Errorf("failed adding/modifying domain %s for project %s, config did not validate: %s", dDrv.FQDN, pCfg.PrettyName(), err)
 	}
 
 	steps := make([]func() error, 0)
-	for _, r := range runners {
+	for _, r := range webRunners(&pCfg) {
 		steps = append(
 			steps,
 			r.Disable,
@@ -252,18 +497,18 @@ func handleDomain(path string, dDrv *project.DomainDirective) error {
 		)
 	}
 
-	if err := Store.Write(e.Project.ID, e.Project); err != nil {
+	if err := Store.Write(pCfg.ID, &pCfg); err != nil {
 		return err
 	}
-	Store.WriteStatus(e.Project.ID, project.StatusUpdating)
+	Store.WriteStatus(pCfg.ID, project.StatusUpdating)
 
-	if err := performSteps(e.Project, steps); err != nil {
-		Store.WriteStatus(e.Project.ID, project.StatusFailed)
-		return fmt.Errorf("failed to add/modify domain %s for project %s: %s", dDrv.FQDN, e.Project.PrettyName(), err)
+	if err := performSteps(&pCfg, steps); err != nil {
+		err = fmt.Errorf("failed to add/modify domain %s for project %s: %s", dDrv.FQDN, pCfg.PrettyName(), err)
+		return handleFailure(&pCfg, prev, err, webRunners)
 	}
 
-	log.Printf("added/modified domain %s for projects %s", dDrv.FQDN, e.Project.PrettyName())
-	Store.WriteStatus(e.Project.ID, project.StatusActive)
+	log.Printf("added/modified domain %s for projects %s", dDrv.FQDN, pCfg.PrettyName())
+	Store.WriteStatus(pCfg.ID, project.StatusActive)
 	return nil
 }
 
@@ -281,7 +526,7 @@ func handleDump(path string, target string) error {
 		dumpers = append(dumpers, runner.NewVolumeRunner(Config, e.Project, SystemdConn))
 	}
 	if Config.Database.Enabled {
-		dumpers = append(dumpers, runner.NewDBRunner(Config, e.Project, MySQLConn))
+		dumpers = append(dumpers, runner.NewDBRunner(Config, e.Project, MySQLConn, PostgreSQLConn))
 	}
 
 	file, err := os.Create(target)
@@ -301,14 +546,53 @@ func handleDump(path string, target string) error {
 	return nil
 }
 
+func handleRestore(path string, source string) error {
+	id := project.PathToID(path)
+
+	if !Store.Has(id) {
+		return fmt.Errorf("no project %s in store", id)
+	}
+	e, _ := Store.Read(id)
+
+	restorers := make([]runner.Restorer, 0)
+
+	if Config.Volume.Enabled {
+		restorers = append(restorers, runner.NewVolumeRunner(Config, e.Project, SystemdConn))
+	}
+	if Config.Database.Enabled {
+		restorers = append(restorers, runner.NewDBRunner(Config, e.Project, MySQLConn, PostgreSQLConn))
+	}
+
+	// Each restorer gets its own pass over the archive, as it is
+	// not possible to rewind a tar stream.
+	for _, r := range restorers {
+		file, err := os.Open(source)
+		if err != nil {
+			return fmt.Errorf("failed to restore project %s from %s: %s", e.Project.PrettyName(), source, err)
+		}
+		err = r.Restore(tar.NewReader(file))
+		file.Close()
+
+		if err != nil {
+			return fmt.Errorf("failed to restore project %s: %s", e.Project.PrettyName(), err)
+		}
+	}
+	log.Printf("restored project %s from %s", e.Project.PrettyName(), source)
+	return nil
+}
+
 func runners(pCfg *project.Config) []runner.Runnable {
 	runners := make([]runner.Runnable, 0)
 
 	if Config.Volume.Enabled && len(pCfg.Volume) > 0 {
 		runners = append(runners, runner.NewVolumeRunner(Config, pCfg, SystemdConn))
 	}
+	if Config.AppService.Enabled || Config.Cron.Enabled || Config.Worker.Enabled {
+		runners = append(runners, runner.NewSliceRunner(Config, pCfg, SystemdConn))
+		runners = append(runners, runner.NewEnvRunner(Config, pCfg))
+	}
 	if Config.Database.Enabled {
-		runners = append(runners, runner.NewDBRunner(Config, pCfg, MySQLConn))
+		runners = append(runners, runner.NewDBRunner(Config, pCfg, MySQLConn, PostgreSQLConn))
 	}
 	if Config.PHP.Enabled {
 		runners = append(runners, runner.NewPHPRunner(Config, pCfg, SystemdConn))
@@ -316,6 +600,9 @@ func runners(pCfg *project.Config) []runner.Runnable {
 	if Config.AppService.Enabled {
 		runners = append(runners, runner.NewAppServiceRunner(Config, pCfg, SystemdConn))
 	}
+	if Config.AppService.Enabled && pCfg.HasLocationServices() {
+		runners = append(runners, runner.NewLocationRunner(Config, pCfg, SystemdConn))
+	}
 	if Config.Web.Enabled && len(pCfg.Domain) > 0 {
 		runners = append(runners, runner.NewWebRunner(Config, pCfg, SystemdConn))
 	}
@@ -329,6 +616,306 @@ func runners(pCfg *project.Config) []runner.Runnable {
 	return runners
 }
 
+// Unlike runners(), includes all enabled runners, regardless of
+// whether the project makes use of them, so we can plan removals, too.
+func planners(pCfg *project.Config) []runner.Planner {
+	planners := make([]runner.Planner, 0)
+
+	if Config.Volume.Enabled {
+		planners = append(planners, runner.NewVolumeRunner(Config, pCfg, SystemdConn))
+	}
+	if Config.AppService.Enabled || Config.Cron.Enabled || Config.Worker.Enabled {
+		planners = append(planners, runner.NewSliceRunner(Config, pCfg, SystemdConn))
+		planners = append(planners, runner.NewEnvRunner(Config, pCfg))
+	}
+	if Config.Database.Enabled {
+		planners = append(planners, runner.NewDBRunner(Config, pCfg, MySQLConn, PostgreSQLConn))
+	}
+	if Config.PHP.Enabled {
+		planners = append(planners, runner.NewPHPRunner(Config, pCfg, SystemdConn))
+	}
+	if Config.AppService.Enabled {
+		planners = append(planners, runner.NewAppServiceRunner(Config, pCfg, SystemdConn))
+		planners = append(planners, runner.NewLocationRunner(Config, pCfg, SystemdConn))
+	}
+	if Config.Web.Enabled {
+		planners = append(planners, runner.NewWebRunner(Config, pCfg, SystemdConn))
+	}
+	if Config.Cron.Enabled {
+		planners = append(planners, runner.NewCronRunner(Config, pCfg, SystemdConn))
+	}
+	if Config.Worker.Enabled {
+		planners = append(planners, runner.NewWorkerRunner(Config, pCfg, SystemdConn))
+	}
+	return planners
+}
+
+// Renders a planned change. Changes to files are rendered as unified
+// diffs using diff(1), all other changes as comments.
+func renderChange(w io.Writer, c runner.Change) error {
+	if c.Note != "" {
+		_, err := fmt.Fprintf(w, "# %s\n", c.Note)
+		return err
+	}
+	source := c.Source
+	if source == "" {
+		source = os.DevNull
+	}
+	out, err := exec.Command(
+		"diff", "-u", "-N",
+		"--label", c.Target,
+		"--label", c.Target,
+		c.Target, source,
+	).Output()
+
+	// diff(1) exits with 1, if there are differences.
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to diff %s against %s: %s", c.Target, source, err)
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// Save us iterating through all runners, when the only one
+// needed for domain updates is the web runner.
+func webRunners(pCfg *project.Config) []runner.Runnable {
+	runners := make([]runner.Runnable, 0)
+
+	if Config.Web.Enabled {
+		runners = append(runners, runner.NewWebRunner(Config, pCfg, SystemdConn))
+	}
+	return runners
+}
+
+// Returns the configuration of the project that is known to work, so
+// we can roll back to it; returns nil if there is none. A project
+// that has been rolled back is running on a working configuration,
+// so is a degraded one, its failing checks aside.
+func lastGoodConfig(id string) *project.Config {
+	if !Store.Has(id) {
+		return nil
+	}
+	e, err := Store.Read(id)
+	if err != nil {
+		return nil
+	}
+	switch e.Meta.Status {
+	case project.StatusActive, project.StatusRolledBack, project.StatusDegraded:
+	default:
+		return nil
+	}
+	return e.Project
+}
+
+// Handles a failed operation on a project, by trying to roll back to
+// the previous working configuration, if there is one. The outcome
+// is recorded in the store. Returns the final error to report.
+func handleFailure(pCfg *project.Config, prev *project.Config, err error, rs func(*project.Config) []runner.Runnable) error {
+	if prev == nil {
+		Store.WriteStatus(pCfg.ID, project.StatusFailed)
+		Store.WriteError(pCfg.ID, err, nil)
+		return err
+	}
+	log.Print(err)
+	log.Printf("rolling back project %s to previous configuration", prev.PrettyName())
+
+	rErr := rollback(pCfg, prev, rs)
+
+	// Even if the rollback failed, it's the previous configuration
+	// that has been applied last.
+	if wErr := Store.Write(prev.ID, prev); wErr != nil {
+		return fmt.Errorf("%s; failed to store previous configuration: %s", err, wErr)
+	}
+	if rErr != nil {
+		Store.WriteStatus(prev.ID, project.StatusFailed)
+		Store.WriteError(prev.ID, err, rErr)
+		return fmt.Errorf("%s; failed to roll back to previous configuration: %s", err, rErr)
+	}
+	Store.WriteStatus(prev.ID, project.StatusRolledBack)
+	Store.WriteError(prev.ID, err, nil)
+
+	log.Printf("project %s rolled back to previous configuration", prev.PrettyName())
+	return fmt.Errorf("%s; rolled back to previous configuration", err)
+}
+
+// Removes whatever the failed configuration left behind, then
+// rebuilds using the previous configuration. Deployers are not
+// disabled, so their previous generation keeps running; it becomes
+// the current one again, when deploying the previous configuration.
+func rollback(pCfg *project.Config, prev *project.Config, rs func(*project.Config) []runner.Runnable) error {
+	steps := cleanupSteps(rs(pCfg), true)
+
+	// Cleaning up is best effort, rebuilding using the previous
+	// configuration will disable most of it anyway.
+	if err := performSteps(pCfg, steps); err != nil {
+		log.Printf("failed to clean up after failed configuration, continuing rollback: %s", err)
+	}
+
+	return performSteps(prev, enableSteps(rs(prev), true))
+}
+
+// Commits the enabled projects one by one, after committing them all
+// at once failed. The projects are returned to their previous
+// configuration first, or cleaned up if they have none, so only
+// projects failing on their own are rolled back. Returns the error of
+// each project, in the order of the given projects; nil projects are
+// skipped.
+func commitIsolated(pCfgs []*project.Config, prevs []*project.Config) []error {
+	errs := make([]error, len(pCfgs))
+
+	for i, pCfg := range pCfgs {
+		if pCfg == nil {
+			continue
+		}
+		steps := cleanupSteps(runners(pCfg), false)
+		if prevs[i] != nil {
+			steps = append(steps, enableSteps(runners(prevs[i]), false)...)
+		}
+		if err := performSteps(pCfg, steps); err != nil {
+			log.Printf("failed to return project %s to previous configuration, continuing: %s", pCfg.PrettyName(), err)
+		}
+	}
+	for i, pCfg := range pCfgs {
+		if pCfg == nil {
+			continue
+		}
+		rs := runners(pCfg)
+		steps := append(enableSteps(rs, true), waitSteps(rs)...)
+
+		if err := performSteps(pCfg, steps); err != nil {
+			err = fmt.Errorf("failed to reload project %s: %s", pCfg.PrettyName(), err)
+
+			// Without a previous configuration to roll back to, the
+			// failed one must still be removed, so it doesn't affect
+			// the projects that follow.
+			if prevs[i] == nil {
+				if cErr := performSteps(pCfg, cleanupSteps(rs, true)); cErr != nil {
+					log.Printf("failed to clean up project %s: %s", pCfg.PrettyName(), cErr)
+				}
+			}
+			errs[i] = handleFailure(pCfg, prevs[i], err, runners)
+			continue
+		}
+		Store.WriteStatus(pCfg.ID, project.StatusActive)
+	}
+	return errs
+}
+
+// Returns the steps removing what the given runners set up, except
+// for Deployers, which replace what they run, once the project is
+// enabled again.
+func cleanupSteps(rs []runner.Runnable, commit bool) []func() error {
+	steps := make([]func() error, 0)
+	for _, r := range rs {
+		if _, ok := r.(runner.Deployer); !ok {
+			steps = append(steps, r.Disable)
+		}
+		if commit {
+			steps = append(steps, r.Commit)
+		}
+	}
+	return steps
+}
+
+// Returns the steps to (re)build a project using the given runners.
+// Most runners are disabled and enabled again, Deployers replace what
+// they run without interruption. When commit is false, the caller
+// must perform commitSteps() and retireSteps() later.
+func enableSteps(rs []runner.Runnable, commit bool) []func() error {
+	steps := make([]func() error, 0)
+	for _, r := range rs {
+		if d, ok := r.(runner.Deployer); ok {
+			steps = append(steps, d.Deploy)
+		} else {
+			steps = append(steps, r.Disable, r.Enable)
+		}
+		if commit {
+			steps = append(steps, r.Commit)
+		}
+	}
+	if commit {
+		steps = append(steps, retireSteps(rs)...)
+	}
+	return steps
+}
+
+// Returns the steps waiting for health checks of Checkers to pass;
+// must follow committing and retiring. Not used when rolling back, the
+// previous configuration may have been degraded already.
+func waitSteps(rs []runner.Runnable) []func() error {
+	steps := make([]func() error, 0)
+	for _, r := range rs {
+		if c, ok := r.(runner.Checker); ok {
+			steps = append(steps, c.Wait)
+		}
+	}
+	return steps
+}
+
+// Returns the steps retiring previous generations of Deployers; must
+// follow committing.
+func retireSteps(rs []runner.Runnable) []func() error {
+	steps := make([]func() error, 0)
+	for _, r := range rs {
+		if d, ok := r.(runner.Deployer); ok {
+			steps = append(steps, d.Retire)
+		}
+	}
+	return steps
+}
+
+// Calls fn for each entity, with up to Config.GetConcurrency() calls
+// running at the same time. Returns the error of each call, in the
+// order of the given entities.
+func forEachProject(es []store.Entity, fn func(i int, e store.Entity) error) []error {
+	errs := make([]error, len(es))
+	sem := make(chan struct{}, Config.GetConcurrency())
+
+	var wg sync.WaitGroup
+	for i, e := range es {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, e store.Entity) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			errs[i] = fn(i, e)
+		}(i, e)
+	}
+	wg.Wait()
+	return errs
+}
+
+func commitSteps(pCfg *project.Config) []func() error {
+	steps := make([]func() error, 0)
+	for _, r := range runners(pCfg) {
+		steps = append(steps, r.Commit)
+	}
+	return steps
+}
+
+// Combines errors of an operation on all projects into a single
+// one; returns nil if there are none.
+func collectErrors(action string, errs []error) error {
+	msgs := make([]string, 0)
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf(
+		"failed to %s %d of %d projects: %s",
+		action, len(msgs), len(errs), strings.Join(msgs, "; "),
+	)
+}
+
 func performSteps(pCfg *project.Config, steps []func() error) error {
 	getFuncName := func(i interface{}) string {
 		name := runtime.FuncForPC(reflect.ValueOf(i).Pointer()).Name()
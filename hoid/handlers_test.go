@@ -0,0 +1,87 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atelierdisko/hoi/server"
+	"github.com/atelierdisko/hoi/store"
+)
+
+func TestForEachProjectKeepsErrorOrder(t *testing.T) {
+	Config = &server.Config{Concurrency: 3}
+	defer func() { Config = nil }()
+
+	es := make([]store.Entity, 6)
+	errs := forEachProject(es, func(i int, e store.Entity) error {
+		// Later projects finish first.
+		time.Sleep(time.Duration(len(es)-i) * time.Millisecond)
+
+		if i%2 == 0 {
+			return fmt.Errorf("failed %d", i)
+		}
+		return nil
+	})
+	if len(errs) != len(es) {
+		t.Fatalf("expected %d errors, got: %d", len(es), len(errs))
+	}
+	for i, err := range errs {
+		if i%2 == 0 && (err == nil || err.Error() != fmt.Sprintf("failed %d", i)) {
+			t.Errorf("unexpected error for project %d: %v", i, err)
+		}
+		if i%2 != 0 && err != nil {
+			t.Errorf("unexpected error for project %d: %v", i, err)
+		}
+	}
+}
+
+func TestForEachProjectBoundsConcurrency(t *testing.T) {
+	Config = &server.Config{Concurrency: 2}
+	defer func() { Config = nil }()
+
+	var mu sync.Mutex
+	running := 0
+	max := 0
+
+	forEachProject(make([]store.Entity, 8), func(i int, e store.Entity) error {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if max > 2 {
+		t.Errorf("expected at most 2 calls at the same time, got: %d", max)
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	if err := collectErrors("reload", make([]error, 3)); err != nil {
+		t.Errorf("expected no error, got: %s", err)
+	}
+
+	err := collectErrors("reload", []error{errors.New("a"), nil, errors.New("c")})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	expected := "failed to reload 2 of 3 projects: a; c"
+	if err.Error() != expected {
+		t.Errorf("result: %s | expected: %s", err, expected)
+	}
+}
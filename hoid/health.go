@@ -0,0 +1,113 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/atelierdisko/hoi/project"
+	"github.com/atelierdisko/hoi/runner"
+)
+
+// How often to look for health checks that are due.
+const healthCheckTick = time.Second
+
+// The health checks of a project, built once for each stored
+// configuration.
+type projectChecks struct {
+	project *project.Config
+	checks  []runner.Check
+}
+
+// Periodically runs the health checks of all active projects, each
+// check in its own interval. Up to Config.GetConcurrency() checks run
+// at the same time, a check still running when it becomes due again
+// is skipped. Runs until the process exits.
+func runHealthChecks() {
+	// Keyed by project ID.
+	checks := make(map[string]projectChecks)
+	// Keyed by project ID and check name.
+	due := make(map[string]time.Time)
+	running := make(map[string]bool)
+
+	done := make(chan string)
+	sem := make(chan struct{}, Config.GetConcurrency())
+
+	ticker := time.NewTicker(healthCheckTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case key := <-done:
+			delete(running, key)
+		case now := <-ticker.C:
+			seen := make(map[string]bool)
+
+			for _, e := range Store.ReadAll() {
+				if e.Meta.Status != project.StatusActive && e.Meta.Status != project.StatusDegraded {
+					continue
+				}
+				seen[e.Project.ID] = true
+
+				pc, ok := checks[e.Project.ID]
+				if !ok || pc.project != e.Project {
+					pc = projectChecks{project: e.Project, checks: buildChecks(e.Project)}
+					checks[e.Project.ID] = pc
+				}
+				for _, check := range pc.checks {
+					key := e.Project.ID + "/" + check.Name
+
+					if running[key] {
+						continue
+					}
+					if next, ok := due[key]; ok && now.Before(next) {
+						continue
+					}
+					due[key] = now.Add(check.Interval)
+					running[key] = true
+
+					go func(pCfg *project.Config, check runner.Check, key string) {
+						sem <- struct{}{}
+						err := check.Run()
+						<-sem
+
+						if err != nil {
+							log.Printf("health check %s of project %s failed: %s", check.Name, pCfg.PrettyName(), err)
+						}
+						if wErr := Store.WriteHealth(pCfg.ID, check.Name, err); wErr != nil {
+							log.Print(wErr)
+						}
+						done <- key
+					}(e.Project, check, key)
+				}
+			}
+			for id := range checks {
+				if !seen[id] {
+					delete(checks, id)
+				}
+			}
+			for key := range due {
+				if !seen[strings.SplitN(key, "/", 2)[0]] {
+					delete(due, key)
+				}
+			}
+		}
+	}
+}
+
+// Collects the health checks of all Checkers of the project.
+func buildChecks(pCfg *project.Config) []runner.Check {
+	checks := make([]runner.Check, 0)
+
+	for _, r := range runners(pCfg) {
+		if c, ok := r.(runner.Checker); ok {
+			checks = append(checks, c.Checks()...)
+		}
+	}
+	return checks
+}
@@ -18,9 +18,11 @@ import (
 	"github.com/atelierdisko/hoi/rpc"
 	"github.com/atelierdisko/hoi/server"
 	"github.com/atelierdisko/hoi/store"
+	"github.com/atelierdisko/hoi/system"
 	"github.com/coreos/go-systemd/dbus"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jawher/mow.cli"
+	_ "github.com/lib/pq"
 )
 
 var (
@@ -40,10 +42,11 @@ var (
 	//
 	// Connections established as per demand, some may never be
 	// setup at all.
-	RPCServer   *rpc.Server
-	Store       *store.Store
-	MySQLConn   *sql.DB
-	SystemdConn *dbus.Conn
+	RPCServer      *rpc.Server
+	Store          *store.Store
+	MySQLConn      *sql.DB
+	PostgreSQLConn *sql.DB
+	SystemdConn    *dbus.Conn
 )
 
 func main() {
@@ -63,13 +66,18 @@ func main() {
 			ProjectAPI: &rpc.ProjectAPI{
 				StatusHandler:    handleStatus,
 				StatusAllHandler: handleStatusAll,
+				UnitsHandler:     handleUnits,
 				LoadHandler:      handleLoad,
+				PlanHandler:      handlePlan,
 				UnloadHandler:    handleUnload,
 				UnloadAllHandler: handleUnloadAll,
 				ReloadHandler:    handleReload,
 				ReloadAllHandler: handleReloadAll,
 				DomainHandler:    handleDomain,
 				DumpHandler:      handleDump,
+				RestoreHandler:   handleRestore,
+				CertsHandler:     handleCerts,
+				CertsAllHandler:  handleCertsAll,
 			},
 		}
 		RPCServer = rpcServer // Assign to global.
@@ -110,6 +118,24 @@ func main() {
 			}
 			MySQLConn = conn // Assign to global.
 			log.Printf("MySQL connection ready")
+
+			if Config.PostgreSQL.IsEnabled() {
+				conn, err := sql.Open("postgres", Config.PostgreSQL.DSN("postgres"))
+				if err != nil {
+					log.Fatal(err)
+				}
+				PostgreSQLConn = conn // Assign to global.
+
+				// The server may not be up yet, only the lack of
+				// privileges is fatal.
+				isSuperuser, err := system.NewPostgreSQL(nil, Config, conn).IsSuperuser()
+				if err != nil {
+					log.Printf("failed to check PostgreSQL role, continuing: %s", err)
+				} else if !isSuperuser {
+					log.Fatalf("PostgreSQL role %s must be a superuser, to verify passwords of roles", Config.PostgreSQL.User)
+				}
+				log.Printf("PostgreSQL connection ready")
+			}
 		}
 		// With the exception of 1 or 2 runners we always need a
 		// systemd connection. By not initializing on demand, we don't
@@ -120,6 +146,15 @@ func main() {
 		}
 		SystemdConn = conn // Assign to global.
 		log.Printf("Systemd DBUS connection ready")
+
+		if Config.SSL.ACME.Enabled {
+			interval, err := Config.SSL.ACME.GetCheckInterval()
+			if err != nil {
+				log.Fatal(err)
+			}
+			go runACME(interval)
+		}
+		go runHealthChecks()
 	}
 
 	// Shutdown gracefully.
@@ -144,6 +179,9 @@ func main() {
 			if MySQLConn != nil {
 				MySQLConn.Close()
 			}
+			if PostgreSQLConn != nil {
+				PostgreSQLConn.Close()
+			}
 			SystemdConn.Close()
 			os.Exit(0)
 		}
@@ -8,6 +8,8 @@ package project
 import (
 	"fmt"
 	"net"
+	"path/filepath"
+	"strings"
 
 	"github.com/atelierdisko/hoi/server"
 	"github.com/atelierdisko/hoi/util"
@@ -26,6 +28,21 @@ const (
 	// A project that uses .php files and optionally routes all requests
 	// through a front controller.
 	AppKindPHP = "php"
+	// A Node.js app, run by an interpreter from the server's runtimes
+	// through the service backend.
+	AppKindNode = "node"
+	// A Python app, run by an interpreter from the server's runtimes
+	// through the service backend.
+	AppKindPython = "python"
+	// An app running outside of hoi, we proxy to; see ProxyDirective.
+	AppKindUpstream = "upstream"
+)
+
+const (
+	// The service listens on a TCP port.
+	AppListenTCP = "tcp"
+	// The service listens on a unix socket.
+	AppListenSocket = "socket"
 )
 
 type AppDirective struct {
@@ -33,13 +50,49 @@ type AppDirective struct {
 	Kind AppKind
 	// The semantic version of the app language to use. For an PHP app
 	// can switch the FPM socket by looking at the major part of the
-	// version, to run projects side by side.
+	// version, to run projects side by side. Node.js and Python apps
+	// select their interpreter by it, i.e. "18" or "3.11".
 	Version string
+	// Either "tcp" (default), to listen on Host and Port, or
+	// "socket", to listen on a unix socket created per project. The
+	// path of the socket is passed to the service as SOCKET
+	// environment variable. Unlike ports, sockets can't be reached
+	// by other projects.
+	//
+	// Used only for service backends.
+	Listen string
 	// Used only for service backends. Defaults to localhost.
 	Host string
 	// Used only for service backends. By default picks the next free
 	// non-privileged port from range.
 	Port uint16
+	// Number of instances of the service to run; defaults to 1. Each
+	// instance listens on its own port, which is passed to it as
+	// PORT environment variable. Requests are balanced between
+	// instances.
+	//
+	// Used only for service backends.
+	Instances int
+	// Ports of the instances, the first one is Port; assigned
+	// automatically.
+	Ports []uint16
+	// How requests are balanced between instances: either
+	// "round-robin" (default), "least-conn" or "ip-hash".
+	//
+	// Used only for service and upstream backends.
+	Balance string
+	// Number of failed attempts to reach an instance within
+	// FailTimeout, after which the instance is considered down for
+	// FailTimeout; defaults to 1, see the max_fails parameter of
+	// NGINX upstream servers.
+	//
+	// Used only for service and upstream backends.
+	MaxFails int
+	// Defaults to 10s, see the fail_timeout parameter of NGINX
+	// upstream servers.
+	//
+	// Used only for service and upstream backends.
+	FailTimeout string
 	// Holds a command string, that starts a HTTP server. The command
 	// can either be a path (relative to project root or absolute)
 	// or a template which evaluates to one of both. Templates may
@@ -47,8 +100,36 @@ type AppDirective struct {
 	//
 	//   bin/server -l {.P.App.Host}:{.P.App.Port}
 	//
+	// Node.js and Python apps pass the command to their interpreter,
+	// it defaults to the entry point given by the project's manifest.
+	//
 	// Used only for service backends.
 	Command `hcl:",squash"`
+	// Resource limits of the service; used only for service backends.
+	Limits `hcl:",squash"`
+	// Environment variables of the service; used only for service
+	// backends.
+	Environment `hcl:",squash"`
+	// Whether to deploy without interruption: the new service is
+	// started on a fresh port next to the current one, which keeps
+	// serving until the new one passes its health check. Port must
+	// not be given, as each generation needs its own.
+	//
+	// Used only for service backends.
+	UseBlueGreen bool
+	// Whether systemd listens in place of the service and starts it
+	// on the first request, passing it the listening socket; see
+	// sd_listen_fds(3). As the socket stays open, restarting the
+	// service doesn't drop connections. Can't be used together
+	// with UseBlueGreen.
+	//
+	// Used only for service backends.
+	UseSocketActivation bool
+	// Checks whether the service is able to handle requests, each
+	// instance is checked separately.
+	//
+	// Used only for service backends.
+	HealthCheck HealthCheckDirective
 	// Whether we want to use "pretty URLs" by rewriting the incoming
 	// URLs as a GET parameter of the front controller file.
 	//
@@ -64,13 +145,85 @@ type AppDirective struct {
 	UseLegacyFrontController bool
 }
 
+// Whether the app is run through the service backend, either
+// directly or by an interpreter.
+func (drv AppDirective) IsService() bool {
+	return drv.Kind == AppKindService || drv.UsesInterpreter()
+}
+
+// Whether the app runs outside of hoi and requests are proxied to
+// the servers given by the proxy directive.
+func (drv AppDirective) IsUpstream() bool {
+	return drv.Kind == AppKindUpstream
+}
+
+// Whether the app's command is run by an interpreter.
+func (drv AppDirective) UsesInterpreter() bool {
+	return drv.Kind == AppKindNode || drv.Kind == AppKindPython
+}
+
+// Returns the path to the interpreter running the app, as configured
+// in the server's runtimes.
+func (drv AppDirective) GetInterpreter(s *server.Config) (string, error) {
+	var runtime server.RuntimeDirective
+
+	switch drv.Kind {
+	case AppKindNode:
+		runtime = s.Node
+	case AppKindPython:
+		runtime = s.Python
+	default:
+		return "", fmt.Errorf("app kind %s has no interpreter", drv.Kind)
+	}
+	interpreter, err := runtime.GetInterpreter(drv.Version)
+	if err != nil {
+		return "", fmt.Errorf("failed to select %s interpreter: %s", drv.Kind, err)
+	}
+	return interpreter, nil
+}
+
+// Returns the PHP runtime the app runs on, as declared in the
+// server configuration.
+func (drv AppDirective) GetPHPRuntime(s *server.Config) (server.PHPRuntimeDirective, error) {
+	if drv.Kind != AppKindPHP {
+		return server.PHPRuntimeDirective{}, fmt.Errorf("app kind %s has no PHP runtime", drv.Kind)
+	}
+	runtime, err := s.PHP.GetRuntime(drv.Version)
+	if err != nil {
+		return runtime, fmt.Errorf("failed to select PHP runtime: %s", err)
+	}
+	return runtime, nil
+}
+
+// Describes the runtime the app runs on, i.e. "PHP 7.2" or
+// "/opt/node-18/bin/node"; empty for apps without a runtime or if
+// none can be selected.
+func (drv AppDirective) DescribeRuntime(s *server.Config) string {
+	if drv.Kind == AppKindPHP {
+		runtime, err := drv.GetPHPRuntime(s)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace("PHP " + runtime.Version)
+	}
+	if drv.UsesInterpreter() {
+		interpreter, _ := drv.GetInterpreter(s)
+		return interpreter
+	}
+	return ""
+}
+
 // Certain apps (i.e. PHP) have a corresponding service unit that we need to reload
 // on configuration changes. Returns a systemd service unit name including suffix.
 func (drv AppDirective) GetService(p *Config, s *server.Config) (string, error) {
 	if drv.Kind != AppKindPHP {
 		return "", fmt.Errorf("app kind %s has no service unit", drv.Kind)
 	}
-	service, err := util.ParseAndExecuteTemplate("service", s.PHP.Service, struct {
+	runtime, err := drv.GetPHPRuntime(s)
+	if err != nil {
+		return "", err
+	}
+	service, err := util.ParseAndExecuteTemplate("service", runtime.Service, struct {
 		P *Config
 		S *server.Config
 	}{
@@ -80,12 +233,54 @@ func (drv AppDirective) GetService(p *Config, s *server.Config) (string, error)
 	return fmt.Sprintf("%s.service", service), err
 }
 
+// Returns the directory the PHP-FPM pool configuration of the project
+// is installed into.
+func (drv AppDirective) GetPoolPath(p *Config, s *server.Config) (string, error) {
+	if drv.Kind != AppKindPHP {
+		return "", fmt.Errorf("app kind %s has no pool path", drv.Kind)
+	}
+	runtime, err := drv.GetPHPRuntime(s)
+	if err != nil {
+		return "", err
+	}
+	return util.ParseAndExecuteTemplate("poolPath", runtime.PoolPath, struct {
+		P *Config
+		S *server.Config
+	}{
+		P: p,
+		S: s,
+	})
+}
+
+// Returns the path of the socket the PHP-FPM pool of the project
+// listens on.
+func (drv AppDirective) GetPoolSocket(p *Config, s *server.Config) (string, error) {
+	if drv.Kind != AppKindPHP {
+		return "", fmt.Errorf("app kind %s has no pool socket", drv.Kind)
+	}
+	runtime, err := drv.GetPHPRuntime(s)
+	if err != nil {
+		return "", err
+	}
+	return util.ParseAndExecuteTemplate("poolSocket", runtime.PoolSocket, struct {
+		P *Config
+		S *server.Config
+	}{
+		P: p,
+		S: s,
+	})
+}
+
 // Certain apps (i.e. PHP) need further outside configuration.
 func (drv AppDirective) GetRunPath(p *Config, s *server.Config) (string, error) {
 	if drv.Kind != AppKindPHP {
 		return "", fmt.Errorf("app kind %s has no run path", drv.Kind)
 	}
-	return util.ParseAndExecuteTemplate("runPath", s.PHP.RunPath, struct {
+	runtime, err := drv.GetPHPRuntime(s)
+	if err != nil {
+		return "", err
+	}
+	return util.ParseAndExecuteTemplate("runPath", runtime.RunPath, struct {
 		P *Config
 		S *server.Config
 	}{
@@ -94,6 +289,48 @@ func (drv AppDirective) GetRunPath(p *Config, s *server.Config) (string, error)
 	})
 }
 
+// Returns number of instances converting to correct unsigned integer
+// type.
+func (drv AppDirective) GetInstances() uint {
+	if drv.Instances < 1 {
+		return 1
+	}
+	return uint(drv.Instances)
+}
+
+// Returns the ports of all instances. Falls back to Port for
+// configurations that have been augmented before instances were
+// supported.
+func (drv AppDirective) GetPorts() []uint16 {
+	if len(drv.Ports) == 0 && drv.Port != 0 {
+		return []uint16{drv.Port}
+	}
+	return drv.Ports
+}
+
+// Whether the service listens on a unix socket instead of a port.
+func (drv AppDirective) UsesSocket() bool {
+	return drv.Listen == AppListenSocket
+}
+
+// Returns the path of the unix socket the service listens on, inside
+// a directory only for the project.
+func (drv AppDirective) GetSocket(p *Config, s *server.Config) string {
+	return filepath.Join(s.AppService.GetRunPath(), fmt.Sprintf("project_%s", p.ID), "app.sock")
+}
+
+// Returns the NGINX directive selecting the balancing method, an
+// empty string for round-robin, which is the NGINX default.
+func (drv AppDirective) GetBalanceDirective() string {
+	switch drv.Balance {
+	case "least-conn":
+		return "least_conn"
+	case "ip-hash":
+		return "ip_hash"
+	}
+	return ""
+}
+
 // Returns next available port number we want to assign to the app.
 func (drv AppDirective) GetFreePort(p *Config) (uint16, error) {
 	port := uint16(0)
@@ -111,6 +348,27 @@ func (drv AppDirective) GetFreePort(p *Config) (uint16, error) {
 	return port, nil
 }
 
+// Like GetFreePort(), but returns n distinct ports. Ports are kept
+// in use until all have been found, so none is returned twice.
+func (drv AppDirective) GetFreePorts(p *Config, n uint) ([]uint16, error) {
+	ports := make([]uint16, 0, n)
+
+	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", drv.Host, 0))
+	if err != nil {
+		return ports, err
+	}
+	for i := uint(0); i < n; i++ {
+		l, err := net.ListenTCP("tcp", addr)
+		if err != nil {
+			return ports, err
+		}
+		defer l.Close()
+
+		ports = append(ports, uint16(l.Addr().(*net.TCPAddr).Port))
+	}
+	return ports, nil
+}
+
 // Returns major part of version string. Also takes default versions into account.
 func (drv AppDirective) GetMajorVersion(s *server.Config) (int64, error) {
 	v, err := drv.getVersion(s)
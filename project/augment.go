@@ -6,6 +6,7 @@
 package project
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -49,13 +50,20 @@ func (cfg *Config) Augment() error {
 	}
 
 	if cfg.Webroot == "" {
-		if cfg.App.Kind == AppKindService {
+		if cfg.App.IsService() || cfg.App.IsUpstream() {
 			cfg.Webroot = cfg.Path
 			log.Printf("- using project root as webroot: %s", cfg.Webroot)
 		} else {
 			webroot, err := cfg.discoverWebroot()
 			if err != nil {
-				return err
+				// Node.js and Python apps usually don't have a webroot.
+				kind := cfg.discoverRuntimeKind()
+				if cfg.App.Kind != AppKindUnknown || cfg.App.HasCommand() || kind == AppKindUnknown {
+					return err
+				}
+				log.Printf("- detected %s app", kind)
+				cfg.App.Kind = kind
+				webroot = cfg.Path
 			}
 			cfg.Webroot = webroot
 			log.Printf("- detected webroot in: %s", cfg.Webroot)
@@ -75,23 +83,64 @@ func (cfg *Config) Augment() error {
 		} else if _, err := os.Stat(cfg.Path + "/app/composer.json"); err == nil {
 			log.Print("- detected PHP app")
 			cfg.App.Kind = AppKindPHP
+		} else if kind := cfg.discoverRuntimeKind(); kind != AppKindUnknown {
+			log.Printf("- detected %s app", kind)
+			cfg.App.Kind = kind
 		} else {
 			return fmt.Errorf("failed to detect app kind in: %s", cfg.Path)
 		}
 	}
 
-	if cfg.App.Kind == AppKindService {
+	if cfg.App.UsesInterpreter() && !cfg.App.HasCommand() {
+		entrypoint, err := cfg.discoverEntrypoint()
+		if err != nil {
+			return err
+		}
+		cfg.App.Command.Command = entrypoint
+		log.Printf("- using entry point of %s app: %s", cfg.App.Kind, entrypoint)
+	}
+
+	if cfg.App.IsService() && !cfg.App.UsesSocket() {
 		if cfg.App.Host == "" {
 			cfg.App.Host = "localhost"
 		}
+		// Each generation needs its own port, so a fixed one would
+		// prevent the new from starting next to the current one.
+		if cfg.App.UseBlueGreen && cfg.App.Port != 0 {
+			return fmt.Errorf("blue/green deploys need a fresh port on each deploy, but port %d is given", cfg.App.Port)
+		}
 		if cfg.App.Port == 0 {
-			freeport, err := cfg.App.GetFreePort(cfg)
+			freeports, err := cfg.App.GetFreePorts(cfg, cfg.App.GetInstances())
+			if err != nil {
+				return err
+			}
+			cfg.App.Port = freeports[0]
+			cfg.App.Ports = freeports
+			log.Printf("- assigned ports %v to app service", cfg.App.Ports)
+		} else {
+			if cfg.App.GetInstances() > 1 {
+				return fmt.Errorf("multiple app service instances need a port each, but port %d is given", cfg.App.Port)
+			}
+			cfg.App.Ports = []uint16{cfg.App.Port}
+		}
+	}
+
+	for k, l := range cfg.Location {
+		if l.Kind != AppKindService {
+			continue
+		}
+		if l.Host == "" {
+			l.Host = "localhost"
+		}
+		if l.Port == 0 {
+			freeports, err := AppDirective{Host: l.Host}.GetFreePorts(cfg, 1)
 			if err != nil {
 				return err
 			}
-			cfg.App.Port = freeport
-			log.Printf("- assigned port %d to app service", cfg.App.Port)
+			l.Port = freeports[0]
+			log.Printf("- assigned port %d to location %s", l.Port, l.Path)
 		}
+		cfg.Location[k] = l
 	}
 
 	if cfg.App.Kind == AppKindPHP || cfg.App.Kind == AppKindStatic {
@@ -252,3 +301,75 @@ func (cfg Config) discoverWebroot() (string, error) {
 	}
 	return webroot, nil
 }
+
+// Discovers Node.js and Python apps by their manifest files in the
+// project root.
+func (cfg Config) discoverRuntimeKind() AppKind {
+	if _, err := os.Stat(cfg.Path + "/package.json"); err == nil {
+		return AppKindNode
+	}
+	for _, f := range []string{"pyproject.toml", "requirements.txt"} {
+		if _, err := os.Stat(cfg.Path + "/" + f); err == nil {
+			return AppKindPython
+		}
+	}
+	return AppKindUnknown
+}
+
+// Discovers the entry point of Node.js and Python apps, relative to
+// the project root; it is passed to the interpreter as the app's
+// command. For Node.js apps it is taken from the start script or
+// main file given by package.json. Python apps are only detected by
+// their main file: scripts declared in pyproject.toml name functions,
+// which can't be passed to the interpreter, those apps must provide
+// a command.
+func (cfg Config) discoverEntrypoint() (string, error) {
+	var candidates []string
+
+	switch cfg.App.Kind {
+	case AppKindNode:
+		if b, err := ioutil.ReadFile(cfg.Path + "/package.json"); err == nil {
+			var manifest struct {
+				Main    string
+				Scripts map[string]string
+			}
+			if err := json.Unmarshal(b, &manifest); err != nil {
+				return "", fmt.Errorf("failed to parse package.json in %s: %s", cfg.Path, err)
+			}
+			// The interpreter is prepended later.
+			if start := manifest.Scripts["start"]; strings.HasPrefix(start, "node ") {
+				return strings.TrimSpace(strings.TrimPrefix(start, "node ")), nil
+			}
+			if manifest.Main != "" {
+				return manifest.Main, nil
+			}
+		}
+		candidates = []string{"server.js", "index.js"}
+	case AppKindPython:
+		candidates = []string{"main.py", "app.py", "server.py"}
+	}
+	for _, c := range candidates {
+		if _, err := os.Stat(cfg.Path + "/" + c); err == nil {
+			return c, nil
+		}
+	}
+	if cfg.App.Kind == AppKindPython && hasPythonScripts(cfg.Path+"/pyproject.toml") {
+		return "", fmt.Errorf("failed to detect entry point of python app in %s: scripts given in pyproject.toml can't be run directly, please provide a command", cfg.Path)
+	}
+	return "", fmt.Errorf("failed to detect entry point of %s app in %s, please provide a command", cfg.App.Kind, cfg.Path)
+}
+
+// Checks whether the pyproject.toml declares scripts, via a
+// [project.scripts] table.
+func hasPythonScripts(file string) bool {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == "[project.scripts]" {
+			return true
+		}
+	}
+	return false
+}
@@ -33,6 +33,10 @@ type CronDirective struct {
 	// Commands will be executed with the project root path as the current working
 	// directory.
 	Command `hcl:",squash"`
+	// Resource limits for the cron's processes.
+	Limits `hcl:",squash"`
+	// Environment variables for the cron's processes.
+	Environment `hcl:",squash"`
 }
 
 // Generates the ID for the directive, prefers the plain Name, if that
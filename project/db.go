@@ -5,10 +5,20 @@
 
 package project
 
+type DatabaseKind string
+
+const (
+	DatabaseKindMySQL    DatabaseKind = "mysql"
+	DatabaseKindPostgres              = "postgres"
+)
+
 // Hoi can manage the database creation and users for you. It will
 // create a database and users with minimum sets of privileges if they
 // do not exist.
 type DatabaseDirective struct {
+	// The database server to use, either "mysql" (default) or
+	// "postgres".
+	Kind DatabaseKind
 	// Optional database name; defaults to project name with the context
 	// suffixed if context is not prod or dev.
 	Name string
@@ -19,3 +29,10 @@ type DatabaseDirective struct {
 	// to ease development.
 	Password string
 }
+
+func (drv DatabaseDirective) GetKind() DatabaseKind {
+	if drv.Kind == "" {
+		return DatabaseKindMySQL
+	}
+	return drv.Kind
+}
@@ -51,6 +51,17 @@ func (drv *DomainDirective) AddAliases(aliases ...string) {
 	}
 }
 
+// Returns all names the domain is served under: the naked domain,
+// its aliases and the www. prefixed variants of each.
+func (drv DomainDirective) GetNames() []string {
+	names := []string{drv.FQDN, "www." + drv.FQDN}
+
+	for _, alias := range drv.Aliases {
+		names = append(names, alias, "www."+alias)
+	}
+	return names
+}
+
 func (drv DomainDirective) HasAlias(fqdn string) bool {
 	for _, v := range drv.Aliases {
 		if v == fqdn {
@@ -80,12 +91,17 @@ const (
 	CertSelfSigned = "!selfsigned"
 	// Will try to find a cert whitelisted for the system.
 	CertSystem = "!system"
+	// Will obtain a cert automatically via the ACME protocol
+	// (i.e. from Let's Encrypt) and renew it before it expires.
+	CertACME = "!acme"
 )
 const (
 	// Automatically generates certificate key.
 	CertKeyGenerate = "!generate"
 	// Will try to find a certificate key whitelisted for the system.
 	CertKeySystem = "!system"
+	// The key is generated together with the cert obtained via ACME.
+	CertKeyACME = "!acme"
 )
 
 // Certificate files should be named after the domain they belong to. Symlinks
@@ -102,3 +118,8 @@ type SSLDirective struct {
 func (drv SSLDirective) IsEnabled() bool {
 	return drv.Certificate != "" || drv.CertificateKey != ""
 }
+
+// Checks whether certificate and key are obtained via ACME.
+func (drv SSLDirective) IsACME() bool {
+	return drv.Certificate == CertACME
+}
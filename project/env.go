@@ -0,0 +1,121 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/atelierdisko/hoi/util"
+)
+
+// Environment configures the variables passed to processes of the
+// project. It can be given for the project as a whole and for each
+// app, worker and cron; variables of the latter take precedence.
+type Environment struct {
+	// Variables keyed by name. Values may be templates referencing
+	// P (the project configuration) or secret references:
+	//
+	//   env {
+	//     CACHE_PREFIX = "{{.P.Name}}_{{.P.Context}}"
+	//     API_KEY = "!secret:example/api-key"
+	//   }
+	Env map[string]string
+	// Optional path, relative to the project root, to a file with
+	// variables; see EnvironmentFile in systemd.exec(5). Its contents
+	// are copied into the generated environment.
+	EnvFile string
+}
+
+// Names of environment variables must be valid shell identifiers.
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Returns the absolute path to the env file or an empty string, if
+// none was given.
+func (e Environment) GetEnvFile(p *Config) string {
+	if e.EnvFile == "" {
+		return ""
+	}
+	return filepath.Join(p.Path, e.EnvFile)
+}
+
+// Returns the value of a variable with templates evaluated; secret
+// references are left untouched.
+func (e Environment) GetEnvValue(p *Config, name string) (string, error) {
+	return util.ParseAndExecuteTemplate("env", e.Env[name], struct {
+		P *Config
+	}{
+		P: p,
+	})
+}
+
+func (e Environment) validate() error {
+	for name, v := range e.Env {
+		if !envNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name: %s", name)
+		}
+		if _, ref, ok := ParseSecretRef(v); ok && ref == "" {
+			return fmt.Errorf("secret reference %s without name used for environment variable %s", v, name)
+		}
+	}
+	if e.EnvFile != "" {
+		if filepath.IsAbs(e.EnvFile) {
+			return fmt.Errorf("env file path is not relative: %s", e.EnvFile)
+		}
+		if strings.HasPrefix(filepath.Clean(e.EnvFile), "..") {
+			return fmt.Errorf("env file is outside of project: %s", e.EnvFile)
+		}
+	}
+	return nil
+}
+
+// Merges the project's environment with the given one, variables of
+// the latter take precedence. Env files of both are kept, the
+// project's first.
+func (cfg Config) MergeEnvironment(e Environment) (Environment, []string) {
+	merged := Environment{Env: make(map[string]string)}
+
+	for k, v := range cfg.Env {
+		merged.Env[k] = v
+	}
+	for k, v := range e.Env {
+		merged.Env[k] = v
+	}
+
+	files := make([]string, 0, 2)
+	if f := cfg.Environment.GetEnvFile(&cfg); f != "" {
+		files = append(files, f)
+	}
+	if f := e.GetEnvFile(&cfg); f != "" {
+		files = append(files, f)
+	}
+	return merged, files
+}
+
+// Maps each database to the name of the environment variable its
+// URL is exported under: DATABASE_<NAME>_URL, with non-alphanumeric
+// characters of the name replaced by underscores. A project with
+// a single database additionally gets the conventional DATABASE_URL.
+func (cfg Config) GetDatabaseEnvNames() map[string][]string {
+	names := make(map[string][]string, len(cfg.Database))
+
+	for _, db := range cfg.Database {
+		name := strings.Map(func(r rune) rune {
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+				return r
+			}
+			return '_'
+		}, strings.ToUpper(db.Name))
+
+		names[db.Name] = []string{fmt.Sprintf("DATABASE_%s_URL", name)}
+		if len(cfg.Database) == 1 {
+			names[db.Name] = append([]string{"DATABASE_URL"}, names[db.Name]...)
+		}
+	}
+	return names
+}
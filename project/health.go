@@ -0,0 +1,114 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Defaults used when the health check leaves them out.
+const (
+	DefaultHealthCheckInterval    = 10 * time.Second
+	DefaultHealthCheckTimeout     = 5 * time.Second
+	DefaultHealthCheckGracePeriod = 30 * time.Second
+)
+
+// Health checks tell whether an app or worker actually works. They are
+// run periodically by hoid, a project with a failing check is
+// reported as degraded. When loading a project, hoid waits for all
+// checks to pass once.
+//
+// Service apps are checked via HTTP: the check passes as soon as the
+// app answers a GET request to Path with the expected status code.
+// Workers are checked by running Command: the check passes if it
+// exits with 0.
+type HealthCheckDirective struct {
+	// Absolute URL path to request, i.e. "/health"; used only for
+	// service apps, defaults to "/" for blue/green deploys.
+	Path string
+	// The status code the app must respond with; by default any
+	// status code below 400 passes.
+	ExpectStatus int
+	// Holds a command string, which can be either a path (relative to
+	// project root or absolute) or a template which evaluates to one
+	// of both; used only for workers. The command is run as the
+	// server's user inside the project root.
+	Command `hcl:",squash"`
+	// How long to wait between periodic checks, i.e. "1m"; defaults
+	// to 10s.
+	Interval string
+	// How long a single check may take; defaults to 5s.
+	Timeout string
+	// How long to wait for the first check to pass after starting,
+	// i.e. "2m"; defaults to 30s.
+	GracePeriod string
+}
+
+// Whether a health check has been configured.
+func (drv HealthCheckDirective) IsEnabled() bool {
+	return drv.Path != "" || drv.HasCommand()
+}
+
+func (drv HealthCheckDirective) GetPath() string {
+	if drv.Path == "" {
+		return "/"
+	}
+	return drv.Path
+}
+
+// Checks whether the status code passes the check.
+func (drv HealthCheckDirective) IsExpectedStatus(code int) bool {
+	if drv.ExpectStatus != 0 {
+		return code == drv.ExpectStatus
+	}
+	return code < 400
+}
+
+func (drv HealthCheckDirective) GetInterval() time.Duration {
+	return parseDurationOr(drv.Interval, DefaultHealthCheckInterval)
+}
+
+func (drv HealthCheckDirective) GetTimeout() time.Duration {
+	return parseDurationOr(drv.Timeout, DefaultHealthCheckTimeout)
+}
+
+func (drv HealthCheckDirective) GetGracePeriod() time.Duration {
+	return parseDurationOr(drv.GracePeriod, DefaultHealthCheckGracePeriod)
+}
+
+func (drv HealthCheckDirective) validate() error {
+	if drv.Path != "" && !strings.HasPrefix(drv.Path, "/") {
+		return fmt.Errorf("health check path is not absolute: %s", drv.Path)
+	}
+	if drv.ExpectStatus != 0 && (drv.ExpectStatus < 100 || drv.ExpectStatus > 599) {
+		return fmt.Errorf("health check expects invalid status: %d", drv.ExpectStatus)
+	}
+	for _, d := range []string{drv.Interval, drv.Timeout, drv.GracePeriod} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return fmt.Errorf("invalid health check duration: %s", d)
+		}
+	}
+	return nil
+}
+
+func parseDurationOr(v string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(v); err == nil {
+		return d
+	}
+	return fallback
+}
+
+// The result of the latest run of a health check.
+type HealthResult struct {
+	// Empty if the check passed.
+	Error   string
+	Checked time.Time
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/atelierdisko/hoi/server"
+)
+
+// Limits controls the resources units running project code may use,
+// see systemd.resource-control(5). Empty values fall back to the
+// defaults of the server configuration.
+type Limits struct {
+	// Maximum memory in bytes, optionally suffixed with K, M, G or T,
+	// i.e. "512M".
+	Memory string
+	// CPU time relative to the time available on a single CPU, i.e.
+	// "20%" or "200%" to use up to 2 CPUs.
+	CPUQuota string
+	// Maximum number of tasks (processes and threads).
+	TasksMax int
+	// Relative IO weight from 1 to 10000; the system default is 100.
+	IOWeight int
+}
+
+// Returns the limits with unset values taken from the server
+// defaults. Used by unit templates.
+func (l Limits) GetLimits(s *server.Config) Limits {
+	d := s.Limits.Default
+
+	if l.Memory == "" {
+		l.Memory = d.Memory
+		if l.Memory == "" {
+			l.Memory = server.DefaultMemoryLimit
+		}
+	}
+	if l.CPUQuota == "" {
+		l.CPUQuota = d.CPUQuota
+	}
+	if l.TasksMax == 0 {
+		l.TasksMax = d.TasksMax
+	}
+	if l.IOWeight == 0 {
+		l.IOWeight = d.IOWeight
+	}
+	return l
+}
+
+// Checks that values are well-formed.
+func (l Limits) validate() error {
+	if l.Memory != "" {
+		if _, err := ParseMemory(l.Memory); err != nil {
+			return err
+		}
+	}
+	if l.CPUQuota != "" {
+		if _, err := ParseCPUQuota(l.CPUQuota); err != nil {
+			return err
+		}
+	}
+	if l.TasksMax < 0 {
+		return fmt.Errorf("tasks maximum must not be negative, got: %d", l.TasksMax)
+	}
+	if l.IOWeight < 0 || l.IOWeight > 10000 {
+		return fmt.Errorf("IO weight must be between 1 and 10000, got: %d", l.IOWeight)
+	}
+	return nil
+}
+
+// Checks that none of the values exceeds the given ceiling. Empty
+// ceiling values do not limit.
+func (l Limits) validateCeiling(max server.ResourceLimits) error {
+	if l.Memory != "" && max.Memory != "" {
+		v, _ := ParseMemory(l.Memory)
+		m, err := ParseMemory(max.Memory)
+		if err != nil {
+			return fmt.Errorf("invalid server memory ceiling: %s", err)
+		}
+		if v > m {
+			return fmt.Errorf("memory %s exceeds the maximum of %s", l.Memory, max.Memory)
+		}
+	}
+	if l.CPUQuota != "" && max.CPUQuota != "" {
+		v, _ := ParseCPUQuota(l.CPUQuota)
+		m, err := ParseCPUQuota(max.CPUQuota)
+		if err != nil {
+			return fmt.Errorf("invalid server CPU quota ceiling: %s", err)
+		}
+		if v > m {
+			return fmt.Errorf("CPU quota %s exceeds the maximum of %s", l.CPUQuota, max.CPUQuota)
+		}
+	}
+	if max.TasksMax != 0 && l.TasksMax > max.TasksMax {
+		return fmt.Errorf("tasks maximum %d exceeds the maximum of %d", l.TasksMax, max.TasksMax)
+	}
+	if max.IOWeight != 0 && l.IOWeight > max.IOWeight {
+		return fmt.Errorf("IO weight %d exceeds the maximum of %d", l.IOWeight, max.IOWeight)
+	}
+	return nil
+}
+
+// Checks that the IO weight - including the server default - is
+// within the range of BlockIOWeight=, which is used in place of
+// IOWeight= with legacy systemd.
+func (l Limits) validateLegacy(s *server.Config) error {
+	if !s.Systemd.UseLegacy {
+		return nil
+	}
+	if w := l.GetLimits(s).IOWeight; w != 0 && (w < 10 || w > 1000) {
+		return fmt.Errorf("IO weight must be between 10 and 1000 with legacy systemd, got: %d", w)
+	}
+	return nil
+}
+
+// Parses a memory size as understood by systemd into bytes. Binary
+// suffixes K, M, G and T are supported.
+func ParseMemory(v string) (uint64, error) {
+	units := map[byte]uint64{
+		'K': 1 << 10,
+		'M': 1 << 20,
+		'G': 1 << 30,
+		'T': 1 << 40,
+	}
+	multiplier := uint64(1)
+
+	num := v
+	if len(v) > 0 {
+		if m, ok := units[strings.ToUpper(v[len(v)-1:])[0]]; ok {
+			multiplier = m
+			num = v[:len(v)-1]
+		}
+	}
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid memory size %q, must be bytes optionally suffixed with K, M, G or T", v)
+	}
+	if n > math.MaxUint64/multiplier {
+		return 0, fmt.Errorf("memory size %q is too large", v)
+	}
+	return n * multiplier, nil
+}
+
+// Parses a CPU quota percentage, i.e. "150%".
+func ParseCPUQuota(v string) (uint64, error) {
+	if !strings.HasSuffix(v, "%") {
+		return 0, fmt.Errorf("invalid CPU quota %q, must be a percentage", v)
+	}
+	n, err := strconv.ParseUint(strings.TrimSuffix(v, "%"), 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid CPU quota %q, must be a percentage", v)
+	}
+	return n, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Routes requests below an URL prefix to a backend other than the
+// app, which keeps serving all other requests:
+//
+//   location "/api" {
+//     kind = "service"
+//     command = "bin/api -l localhost:$PORT"
+//   }
+//   location "/docs" {
+//     kind = "static"
+//     root = "docs/build"
+//   }
+//
+// Requests are passed to services with the prefix, as they were
+// requested.
+type LocationDirective struct {
+	// The URL prefix, i.e. "/api"; taken from the key.
+	Path string
+	// The kind of backend serving the location: "service", "static"
+	// or "php". PHP locations are served by the PHP-FPM pool of the
+	// app, which must be a PHP app.
+	Kind AppKind
+	// Directory served under the prefix, relative to the project
+	// root; used only for static and PHP backends.
+	Root string
+	// Whether requests to files that don't exist are routed through
+	// the front controller inside Root; used only for static and PHP
+	// backends.
+	UseFrontController bool
+	// Used only for service backends. Defaults to localhost.
+	Host string
+	// Used only for service backends. By default picks the next free
+	// non-privileged port from range, which is passed to the service
+	// as PORT environment variable.
+	Port uint16
+	// Holds a command string, that starts a HTTP server, see
+	// AppDirective.Command; used only for service backends.
+	Command `hcl:",squash"`
+	// Resource limits of the service; used only for service backends.
+	Limits `hcl:",squash"`
+	// Environment variables of the service; used only for service
+	// backends.
+	Environment `hcl:",squash"`
+}
+
+var locationPathRegex = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)+$`)
+
+// Generates the ID for the directive from its path, i.e. "api-v1" for
+// "/api/v1".
+func (drv LocationDirective) GetID() string {
+	return strings.Replace(strings.TrimPrefix(drv.Path, "/"), "/", "-", -1)
+}
+
+// Returns the absolute path of the directory served under the prefix.
+func (drv LocationDirective) GetRoot(p *Config) string {
+	return filepath.Join(p.Path, drv.Root)
+}
+
+func (drv LocationDirective) validate(p Config) error {
+	if !locationPathRegex.MatchString(drv.Path) {
+		return fmt.Errorf("invalid path, must start but not end with a slash: %s", drv.Path)
+	}
+	switch drv.Kind {
+	case AppKindService:
+		if !drv.HasCommand() {
+			return fmt.Errorf("service has no command")
+		}
+		if drv.Root != "" || drv.UseFrontController {
+			return fmt.Errorf("service can't have a root or front controller")
+		}
+		return nil
+	case AppKindStatic, AppKindPHP:
+		if drv.Kind == AppKindPHP && p.App.Kind != AppKindPHP {
+			return fmt.Errorf("PHP locations need a PHP app, whose pool serves them")
+		}
+		if drv.HasCommand() || drv.Port != 0 {
+			return fmt.Errorf("%s location can't have a command or port", drv.Kind)
+		}
+		root := filepath.Clean(drv.Root)
+		if drv.Root == "" || filepath.IsAbs(root) || root == ".." || strings.HasPrefix(root, "../") {
+			return fmt.Errorf("root must be a directory inside the project: %s", drv.Root)
+		}
+		if fi, err := os.Stat(drv.GetRoot(&p)); err != nil || !fi.IsDir() {
+			return fmt.Errorf("root is not an accessible directory: %s", drv.Root)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported kind: %s", drv.Kind)
+}
+
+// Returns locations ordered by path, so generated configuration is
+// stable.
+func (cfg Config) GetLocations() []LocationDirective {
+	locations := make([]LocationDirective, 0, len(cfg.Location))
+
+	for _, l := range cfg.Location {
+		locations = append(locations, l)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		return locations[i].Path < locations[j].Path
+	})
+	return locations
+}
+
+// Whether any location is served by a service.
+func (cfg Config) HasLocationServices() bool {
+	for _, l := range cfg.Location {
+		if l.Kind == AppKindService {
+			return true
+		}
+	}
+	return false
+}
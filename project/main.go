@@ -84,6 +84,11 @@ func decodeInto(cfg *Config, s string) (*Config, error) {
 			)
 			e.SSL.Certificate = CertSelfSigned
 		}
+		// The key of an ACME cert can only be generated together
+		// with the cert, allow to leave it out.
+		if e.SSL.Certificate == CertACME && e.SSL.CertificateKey == "" {
+			e.SSL.CertificateKey = CertKeyACME
+		}
 		cfg.Domain[k] = e
 	}
 
@@ -112,6 +117,11 @@ func decodeInto(cfg *Config, s string) (*Config, error) {
 		e.Path = k
 		cfg.Volume[k] = e
 	}
+	for k, _ := range cfg.Location {
+		e := cfg.Location[k]
+		e.Path = k
+		cfg.Location[k] = e
+	}
 
 	// Handle deprecated configuration, default values for these
 	// settings are false. So when setting is true we can safely
@@ -176,6 +186,10 @@ type Config struct {
 	Context ContextType
 	// App backend configuration; mostly detected automatically.
 	App AppDirective
+	// PHP-FPM pool configuration; used only for PHP apps.
+	PHP PHPDirective
+	// Where to proxy requests to; used only for upstream apps.
+	Proxy ProxyDirective
 	// A path relative to the project path. If the special value "."
 	// is given webroot is equal to the project path. A webroot is the
 	// directory exposed under the root of the domains any may contain
@@ -214,6 +228,14 @@ type Config struct {
 	Database map[string]DatabaseDirective
 	// Volumes for the project
 	Volume map[string]VolumeDirective
+	// Backends for URL prefixes, routed to in addition to the app.
+	Location map[string]LocationDirective
+	// Resource limits for the project as a whole, applied to the
+	// slice all units of the project are placed in. Empty values
+	// don't limit.
+	Limits Limits
+	// Environment variables passed to all processes of the project.
+	Environment `hcl:",squash"`
 
 	// Deprecated, both settings have been moved below App.
 	UseFrontController       bool
@@ -127,3 +127,15 @@ database foo {
 		t.Error("No 1 db parsed")
 	}
 }
+
+func TestFreePortsAreDistinct(t *testing.T) {
+	app := AppDirective{Host: "localhost"}
+
+	ports, err := app.GetFreePorts(&Config{}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 3 || ports[0] == ports[1] || ports[1] == ports[2] || ports[0] == ports[2] {
+		t.Errorf("expected 3 distinct ports, got: %v", ports)
+	}
+}
@@ -13,6 +13,12 @@ const (
 	StatusUpdating
 	StatusActive
 	StatusFailed
+	// The last operation failed, but the project has been rolled back
+	// to and is active with its previous configuration.
+	StatusRolledBack
+	// The project is active, but at least one of its health checks
+	// currently fails.
+	StatusDegraded
 )
 
 //go:generate stringer -type=MetaStatus
@@ -20,4 +26,16 @@ type MetaStatus int
 
 type Meta struct {
 	Status MetaStatus
+	// The error the last operation on the project failed with; empty
+	// if the last operation succeeded.
+	Error string
+	// The error the rollback to the previous configuration failed
+	// with; empty if no rollback was necessary or it succeeded.
+	RollbackError string
+	// Results of the latest health checks, keyed by the name of the
+	// check, i.e. "app" or "worker media".
+	Health map[string]HealthResult
+	// Describes the runtime the app runs on, i.e. "PHP 7.2"; only
+	// filled in when querying the status.
+	Runtime string
 }
@@ -4,9 +4,9 @@ package project
 
 import "fmt"
 
-const _MetaStatus_name = "StatusUnknownStatusLoadingStatusUnloadingStatusReloadingStatusUpdatingStatusActiveStatusFailed"
+const _MetaStatus_name = "StatusUnknownStatusLoadingStatusUnloadingStatusReloadingStatusUpdatingStatusActiveStatusFailedStatusRolledBackStatusDegraded"
 
-var _MetaStatus_index = [...]uint8{0, 13, 26, 41, 56, 70, 82, 94}
+var _MetaStatus_index = [...]uint8{0, 13, 26, 41, 56, 70, 82, 94, 110, 124}
 
 func (i MetaStatus) String() string {
 	if i < 0 || i >= MetaStatus(len(_MetaStatus_index)-1) {
@@ -0,0 +1,191 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/atelierdisko/hoi/server"
+)
+
+// Used when the project doesn't give a maximum number of PHP
+// processes.
+const DefaultPHPMaxChildren = 5
+
+// Configures the PHP-FPM pool of a PHP app. Each project gets its own
+// pool, processes run as the server's user.
+//
+//   php {
+//     pm = "ondemand"
+//     maxChildren = 10
+//     settings = {
+//       date.timezone = "Europe/Berlin"
+//       max_execution_time = "60"
+//     }
+//     adminValues = {
+//       memory_limit = "256M"
+//     }
+//   }
+//
+// Which settings projects may give is restricted by the server
+// configuration.
+type PHPDirective struct {
+	// How the number of processes is controlled: "dynamic"
+	// (default), "static" or "ondemand"; see the pm setting of
+	// PHP-FPM.
+	PM string
+	// Maximum number of processes serving requests at the same time;
+	// defaults to 5.
+	MaxChildren int
+	// PHP settings of the pool, scripts may change them; see
+	// php_value of PHP-FPM. The special "open_basedir" setting holds
+	// paths, separated by colons, which are made accessible in
+	// addition to the project root.
+	Settings map[string]string
+	// PHP settings of the pool, which can't be changed by scripts;
+	// see php_admin_value of PHP-FPM. Settings hoi manages, i.e.
+	// open_basedir and upload limits, can't be given.
+	AdminValues map[string]string
+}
+
+var phpSettingRegex = regexp.MustCompile(`^[a-z0-9_.]+$`)
+
+// Settings hoi gives as admin values to sandbox the pool, projects
+// must not override them.
+var reservedPHPAdminValues = []string{
+	"open_basedir",
+	"sys_temp_dir",
+	"upload_tmp_dir",
+	"upload_max_filesize",
+	"post_max_size",
+}
+
+// Returns the settings scripts may change, without open_basedir.
+func (drv PHPDirective) GetValues() map[string]string {
+	values := make(map[string]string, len(drv.Settings))
+	for k, v := range drv.Settings {
+		if k != "open_basedir" {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+// Returns the paths accessible to scripts: the project root and
+// those given by the open_basedir setting.
+func (drv PHPDirective) GetOpenBasedir(p *Config) string {
+	paths := []string{p.Path, "/dev/urandom"}
+
+	if extra := drv.Settings["open_basedir"]; extra != "" {
+		paths = append(paths, extra)
+	}
+	return strings.Join(paths, ":")
+}
+
+func (drv PHPDirective) GetPM() string {
+	if drv.PM == "" {
+		return "dynamic"
+	}
+	return drv.PM
+}
+
+func (drv PHPDirective) GetMaxChildren() int {
+	if drv.MaxChildren == 0 {
+		return DefaultPHPMaxChildren
+	}
+	return drv.MaxChildren
+}
+
+// Returns the number of idle processes to keep at least, with the
+// dynamic process manager. Pools start with this number of processes.
+func (drv PHPDirective) GetMinSpareServers() int {
+	return 1
+}
+
+// Returns the number of idle processes to keep at most, with the
+// dynamic process manager: half of the maximum.
+func (drv PHPDirective) GetMaxSpareServers() int {
+	if max := drv.GetMaxChildren() / 2; max > 1 {
+		return max
+	}
+	return 1
+}
+
+func (drv PHPDirective) validate() error {
+	switch drv.PM {
+	case "", "dynamic", "static", "ondemand":
+	default:
+		return fmt.Errorf("unknown process manager: %s", drv.PM)
+	}
+	if drv.MaxChildren < 0 {
+		return fmt.Errorf("negative number of max children: %d", drv.MaxChildren)
+	}
+	for _, settings := range []map[string]string{drv.Settings, drv.AdminValues} {
+		for k, v := range settings {
+			if !phpSettingRegex.MatchString(k) {
+				return fmt.Errorf("invalid setting name: %s", k)
+			}
+			// Values are written into the pool configuration verbatim.
+			if strings.ContainsAny(v, "\n\r") {
+				return fmt.Errorf("setting %s has a multi-line value", k)
+			}
+		}
+	}
+	for _, k := range reservedPHPAdminValues {
+		if _, ok := drv.AdminValues[k]; ok {
+			return fmt.Errorf("setting %s is managed by hoi and can't be given as admin value", k)
+		}
+	}
+	for _, path := range filepath.SplitList(drv.Settings["open_basedir"]) {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("open_basedir path is not absolute: %s", path)
+		}
+	}
+	return nil
+}
+
+// Checks settings against those the server permits, errors name the
+// first offending setting. Paths given via open_basedir must be inside
+// the project root or one of the paths the server allows.
+func (drv PHPDirective) validatePermitted(s *server.Config, root string) error {
+	for _, settings := range []map[string]string{drv.Settings, drv.AdminValues} {
+		for k := range settings {
+			if !s.PHP.IsSettingPermitted(k) {
+				return fmt.Errorf("PHP setting %s is not permitted by the server", k)
+			}
+		}
+	}
+	for _, path := range filepath.SplitList(drv.Settings["open_basedir"]) {
+		if !isOpenBasedirPermitted(path, root, s.PHP.AllowOpenBasedir) {
+			return fmt.Errorf("open_basedir path %s is outside the project and not permitted by the server", path)
+		}
+	}
+	return nil
+}
+
+// Checks whether path is inside root or one of the allowed paths.
+// Symlinks are resolved, where the paths exist, so a link inside the
+// project can't be used to widen access.
+func isOpenBasedirPermitted(path string, root string, allowed []string) bool {
+	resolve := func(path string) string {
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			return real
+		}
+		return filepath.Clean(path)
+	}
+	path = resolve(path)
+
+	for _, dir := range append([]string{root}, allowed...) {
+		dir = resolve(dir)
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Used to verify certificates of upstreams, when the project doesn't
+// give its own trusted certificates.
+const DefaultProxyTrustedCertificate = "/etc/ssl/certs/ca-certificates.crt"
+
+// Configures how requests are proxied to the servers of an upstream
+// app, which run outside of hoi, i.e. in a container, on another
+// machine or at a hosting provider:
+//
+//   proxy {
+//     urls = ["https://10.0.0.5:8443", "https://10.0.0.6:8443"]
+//     host = "origin.example.com"
+//     verifyTLS = true
+//     setHeaders = {
+//       X-Forwarded-Proto = "$scheme"
+//     }
+//     hideHeaders = ["X-Powered-By"]
+//     readTimeout = "2m"
+//   }
+//
+// Domains, SSL and authentication are handled by hoi as for any other
+// app.
+type ProxyDirective struct {
+	// URLs of the upstream servers, i.e. "http://10.0.0.5:8080";
+	// requests are balanced between them. All must use the same
+	// scheme, paths are not supported.
+	URLs []string
+	// The Host header sent to the upstream; defaults to the host
+	// requested by the client.
+	Host string
+	// The name to request via SNI and to verify the certificate of
+	// the upstream against; defaults to Host or the host of the first
+	// URL. Used only for HTTPS upstreams.
+	ServerName string
+	// Whether to verify the certificate of the upstream; used only
+	// for HTTPS upstreams.
+	VerifyTLS bool
+	// Path to a file with trusted CA certificates in PEM format, used
+	// to verify the certificate of the upstream; defaults to the
+	// system's certificates.
+	TrustedCertificate string
+	// Headers to send to the upstream in addition; values may contain
+	// NGINX variables, i.e. "$scheme".
+	SetHeaders map[string]string
+	// Headers of upstream responses, which are not passed to the
+	// client.
+	HideHeaders []string
+	// Time to wait for a connection to the upstream to be
+	// established, i.e. "5s"; defaults to 60s.
+	ConnectTimeout string
+	// Time to wait between two reads from the upstream; defaults to
+	// 60s.
+	ReadTimeout string
+	// Time to wait between two writes to the upstream; defaults to
+	// 60s.
+	SendTimeout string
+}
+
+var proxyHeaderRegex = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// Returns the scheme shared by all URLs, either "http" or "https".
+func (drv ProxyDirective) GetScheme() string {
+	if len(drv.URLs) == 0 {
+		return "http"
+	}
+	u, err := url.Parse(drv.URLs[0])
+	if err != nil || u.Scheme == "" {
+		return "http"
+	}
+	return u.Scheme
+}
+
+func (drv ProxyDirective) UsesTLS() bool {
+	return drv.GetScheme() == "https"
+}
+
+// Returns the address of each upstream server, with the port
+// defaulting to the one of the scheme.
+func (drv ProxyDirective) GetServers() []string {
+	servers := make([]string, 0, len(drv.URLs))
+
+	for _, v := range drv.URLs {
+		u, err := url.Parse(v)
+		if err != nil {
+			continue
+		}
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		servers = append(servers, net.JoinHostPort(u.Hostname(), port))
+	}
+	return servers
+}
+
+func (drv ProxyDirective) GetServerName() string {
+	if drv.ServerName != "" {
+		return drv.ServerName
+	}
+	if drv.Host != "" {
+		return drv.Host
+	}
+	if len(drv.URLs) > 0 {
+		if u, err := url.Parse(drv.URLs[0]); err == nil {
+			return u.Hostname()
+		}
+	}
+	return ""
+}
+
+func (drv ProxyDirective) GetTrustedCertificate() string {
+	if drv.TrustedCertificate == "" {
+		return DefaultProxyTrustedCertificate
+	}
+	return drv.TrustedCertificate
+}
+
+// Timeouts are returned in a format NGINX understands, i.e. "5000ms";
+// empty if not given.
+func (drv ProxyDirective) GetConnectTimeout() string {
+	return formatNGINXTime(drv.ConnectTimeout)
+}
+
+func (drv ProxyDirective) GetReadTimeout() string {
+	return formatNGINXTime(drv.ReadTimeout)
+}
+
+func (drv ProxyDirective) GetSendTimeout() string {
+	return formatNGINXTime(drv.SendTimeout)
+}
+
+func formatNGINXTime(v string) string {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
+
+func (drv ProxyDirective) validate() error {
+	var scheme string
+
+	for _, v := range drv.URLs {
+		u, err := url.Parse(v)
+		if err != nil {
+			return fmt.Errorf("invalid URL: %s", v)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("URL must use http or https: %s", v)
+		}
+		if scheme != "" && u.Scheme != scheme {
+			return fmt.Errorf("URLs must all use the same scheme: %s", v)
+		}
+		scheme = u.Scheme
+
+		if u.Hostname() == "" {
+			return fmt.Errorf("URL has no host: %s", v)
+		}
+		if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+			return fmt.Errorf("URL must consist of scheme, host and port only: %s", v)
+		}
+	}
+	if scheme != "https" && (drv.ServerName != "" || drv.VerifyTLS || drv.TrustedCertificate != "") {
+		return fmt.Errorf("TLS options given, but URLs don't use https")
+	}
+	if drv.TrustedCertificate != "" && !filepath.IsAbs(drv.TrustedCertificate) {
+		return fmt.Errorf("trusted certificate path is not absolute: %s", drv.TrustedCertificate)
+	}
+	// Values are written into the NGINX configuration verbatim.
+	hosts := []string{drv.Host, drv.ServerName}
+	for _, v := range drv.URLs {
+		if u, err := url.Parse(v); err == nil {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	for _, v := range hosts {
+		if strings.ContainsAny(v, " \t\n\r;\"'{}") {
+			return fmt.Errorf("invalid host: %s", v)
+		}
+	}
+	for k, v := range drv.SetHeaders {
+		if !proxyHeaderRegex.MatchString(k) {
+			return fmt.Errorf("invalid header name: %s", k)
+		}
+		if strings.EqualFold(k, "Host") {
+			return fmt.Errorf("use host instead of setting the Host header")
+		}
+		if strings.ContainsAny(v, "\n\r\"\\") {
+			return fmt.Errorf("header %s has an invalid value", k)
+		}
+	}
+	for _, k := range drv.HideHeaders {
+		if !proxyHeaderRegex.MatchString(k) {
+			return fmt.Errorf("invalid header name: %s", k)
+		}
+	}
+	for _, d := range []string{drv.ConnectTimeout, drv.ReadTimeout, drv.SendTimeout} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v < time.Millisecond {
+			return fmt.Errorf("invalid timeout: %s", d)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import "strings"
+
+// Secret references may be used in place of plaintext values, i.e.
+// for passwords, so that credentials don't need to be committed with
+// the Hoifile. References are resolved by hoid only when the value is
+// actually needed; the configuration itself always keeps the
+// reference.
+const (
+	// References a secret of the secret provider configured in
+	// hoid.conf, i.e. "!secret:db/example".
+	SecretRefPrefix = "!secret:"
+	// References an environment variable of hoid,
+	// i.e. "!env:DB_PASSWORD".
+	EnvRefPrefix = "!env:"
+)
+
+// Checks whether the value is a reference to a secret.
+func IsSecretRef(v string) bool {
+	return strings.HasPrefix(v, SecretRefPrefix) || strings.HasPrefix(v, EnvRefPrefix)
+}
+
+// Splits a secret reference into its prefix and the name of the
+// referenced secret. Returns false if the value is no reference.
+func ParseSecretRef(v string) (string, string, bool) {
+	for _, prefix := range []string{SecretRefPrefix, EnvRefPrefix} {
+		if strings.HasPrefix(v, prefix) {
+			return prefix, strings.TrimPrefix(v, prefix), true
+		}
+	}
+	return "", "", false
+}
@@ -6,10 +6,17 @@
 package project
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/atelierdisko/hoi/server"
 )
 
 // Validates several aspects and looks for typical human errors. This
@@ -34,12 +41,262 @@ func (cfg Config) Validate() error {
 	if err := cfg.validateDomainsSSL(); err != nil {
 		return err
 	}
+	if err := cfg.validateDomainsSSLFiles(); err != nil {
+		return err
+	}
 	if err := cfg.validateDatabases(); err != nil {
 		return err
 	}
+	if err := cfg.validateSecretRefs(); err != nil {
+		return err
+	}
 	if err := cfg.validateVolumes(); err != nil {
 		return err
 	}
+	if err := cfg.validateLimits(); err != nil {
+		return err
+	}
+	if err := cfg.validateEnvironments(); err != nil {
+		return err
+	}
+	if err := cfg.validateApp(); err != nil {
+		return err
+	}
+	if err := cfg.PHP.validate(); err != nil {
+		return fmt.Errorf("project has invalid PHP configuration: %s", err)
+	}
+	if err := cfg.Proxy.validate(); err != nil {
+		return fmt.Errorf("project has invalid proxy configuration: %s", err)
+	}
+	if err := cfg.validateLocations(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Blue/green deploys and multiple instances are only possible for
+// service apps; ports are checked by Augment(), before it assigns
+// them. Apps are checked via HTTP, workers via commands. Upstream
+// apps must give where to proxy to, other apps must not.
+func (cfg Config) validateApp() error {
+	if err := cfg.App.HealthCheck.validate(); err != nil {
+		return fmt.Errorf("app has invalid health check: %s", err)
+	}
+	if cfg.App.HealthCheck.HasCommand() {
+		return fmt.Errorf("app health check must use a path, not a command")
+	}
+	for _, w := range cfg.Worker {
+		if err := w.HealthCheck.validate(); err != nil {
+			return fmt.Errorf("worker %s has invalid health check: %s", w.GetID(), err)
+		}
+		if w.HealthCheck.Path != "" || w.HealthCheck.ExpectStatus != 0 {
+			return fmt.Errorf("worker %s health check must use a command, not a path", w.GetID())
+		}
+	}
+	if cfg.App.IsUpstream() {
+		if len(cfg.Proxy.URLs) == 0 {
+			return fmt.Errorf("upstream app has no URLs to proxy to")
+		}
+		// Upstreams run outside of hoi, there's nothing to start.
+		if cfg.App.HasCommand() || cfg.App.HealthCheck.IsEnabled() {
+			return fmt.Errorf("upstream app can't have a command or health check")
+		}
+	} else if len(cfg.Proxy.URLs) > 0 {
+		return fmt.Errorf("proxying is not supported for app kind: %s", cfg.App.Kind)
+	}
+	if cfg.App.Instances < 0 || cfg.App.MaxFails < 0 {
+		return fmt.Errorf("app has negative number of instances or max fails")
+	}
+	if cfg.App.Instances > 1 {
+		if !cfg.App.IsService() {
+			return fmt.Errorf("multiple instances are not supported for app kind: %s", cfg.App.Kind)
+		}
+		// All instances share the same command.
+		if strings.Contains(cfg.App.Command.Command, ".P.App.Port") {
+			return fmt.Errorf("app command must use $PORT instead of {{.P.App.Port}} with multiple instances")
+		}
+	}
+	switch cfg.App.Balance {
+	case "", "round-robin", "least-conn", "ip-hash":
+	default:
+		return fmt.Errorf("app has unknown balancing method: %s", cfg.App.Balance)
+	}
+	if cfg.App.FailTimeout != "" {
+		if _, err := time.ParseDuration(cfg.App.FailTimeout); err != nil {
+			return fmt.Errorf("app has invalid fail timeout: %s", cfg.App.FailTimeout)
+		}
+	}
+	switch cfg.App.Listen {
+	case "", AppListenTCP:
+	case AppListenSocket:
+		if !cfg.App.IsService() {
+			return fmt.Errorf("listening on a socket is not supported for app kind: %s", cfg.App.Kind)
+		}
+		// There is just one socket per project.
+		if cfg.App.Instances > 1 || cfg.App.UseBlueGreen {
+			return fmt.Errorf("app listening on a socket can't have multiple instances or use blue/green deploys")
+		}
+		if cfg.App.Port != 0 {
+			return fmt.Errorf("app listening on a socket can't have a port: %d", cfg.App.Port)
+		}
+	default:
+		return fmt.Errorf("app has unknown listen type: %s", cfg.App.Listen)
+	}
+	if cfg.App.UseSocketActivation {
+		if !cfg.App.IsService() {
+			return fmt.Errorf("socket activation is not supported for app kind: %s", cfg.App.Kind)
+		}
+		if cfg.App.UseBlueGreen {
+			return fmt.Errorf("app can't use both socket activation and blue/green deploys")
+		}
+	}
+	if !cfg.App.UseBlueGreen {
+		return nil
+	}
+	if !cfg.App.IsService() {
+		return fmt.Errorf("blue/green deploys are not supported for app kind: %s", cfg.App.Kind)
+	}
+	return nil
+}
+
+// Locations must not share an ID, as their units are named after it.
+func (cfg Config) validateLocations() error {
+	ids := make(map[string]string, len(cfg.Location))
+
+	for _, l := range cfg.Location {
+		if err := l.validate(cfg); err != nil {
+			return fmt.Errorf("location %s is invalid: %s", l.Path, err)
+		}
+		if other, ok := ids[l.GetID()]; ok {
+			return fmt.Errorf("locations %s and %s can't be told apart", other, l.Path)
+		}
+		ids[l.GetID()] = l.Path
+	}
+	return nil
+}
+
+// Checks variable names and env file paths, env files must exist.
+func (cfg Config) validateEnvironments() error {
+	check := func(e Environment, what string) error {
+		if err := e.validate(); err != nil {
+			return fmt.Errorf("%s has invalid environment: %s", what, err)
+		}
+		if f := e.GetEnvFile(&cfg); f != "" {
+			if _, err := os.Stat(f); err != nil {
+				return fmt.Errorf("%s has inaccessible env file: %s", what, err)
+			}
+		}
+		return nil
+	}
+	if err := check(cfg.Environment, "project"); err != nil {
+		return err
+	}
+	if err := check(cfg.App.Environment, "app"); err != nil {
+		return err
+	}
+	for _, c := range cfg.Cron {
+		if err := check(c.Environment, "cron "+c.GetID()); err != nil {
+			return err
+		}
+	}
+	for _, w := range cfg.Worker {
+		if err := check(w.Environment, "worker "+w.GetID()); err != nil {
+			return err
+		}
+	}
+	for _, l := range cfg.Location {
+		if err := check(l.Environment, "location "+l.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks that limits are well-formed, ValidateLimits() checks them
+// against the server's ceilings.
+func (cfg Config) validateLimits() error {
+	if err := cfg.Limits.validate(); err != nil {
+		return fmt.Errorf("project has invalid limits: %s", err)
+	}
+	if err := cfg.App.Limits.validate(); err != nil {
+		return fmt.Errorf("app has invalid limits: %s", err)
+	}
+	for _, c := range cfg.Cron {
+		if err := c.Limits.validate(); err != nil {
+			return fmt.Errorf("cron %s has invalid limits: %s", c.GetID(), err)
+		}
+	}
+	for _, w := range cfg.Worker {
+		if err := w.Limits.validate(); err != nil {
+			return fmt.Errorf("worker %s has invalid limits: %s", w.GetID(), err)
+		}
+	}
+	for _, l := range cfg.Location {
+		if err := l.Limits.validate(); err != nil {
+			return fmt.Errorf("location %s has invalid limits: %s", l.Path, err)
+		}
+	}
+	return nil
+}
+
+// Validates resource limits against the ceilings of the server
+// configuration and the range of values its systemd supports. Must
+// be called after Validate().
+func (cfg Config) ValidateLimits(s *server.Config) error {
+	max := s.Limits.Max
+
+	if err := cfg.Limits.validateLegacy(s); err != nil {
+		return fmt.Errorf("project has invalid limits: %s", err)
+	}
+	if err := cfg.App.Limits.validateCeiling(max); err != nil {
+		return fmt.Errorf("app exceeds limits: %s", err)
+	}
+	if err := cfg.App.Limits.validateLegacy(s); err != nil {
+		return fmt.Errorf("app has invalid limits: %s", err)
+	}
+	for _, c := range cfg.Cron {
+		if err := c.Limits.validateCeiling(max); err != nil {
+			return fmt.Errorf("cron %s exceeds limits: %s", c.GetID(), err)
+		}
+		if err := c.Limits.validateLegacy(s); err != nil {
+			return fmt.Errorf("cron %s has invalid limits: %s", c.GetID(), err)
+		}
+	}
+	for _, w := range cfg.Worker {
+		if err := w.Limits.validateCeiling(max); err != nil {
+			return fmt.Errorf("worker %s exceeds limits: %s", w.GetID(), err)
+		}
+		if err := w.Limits.validateLegacy(s); err != nil {
+			return fmt.Errorf("worker %s has invalid limits: %s", w.GetID(), err)
+		}
+	}
+	for _, l := range cfg.Location {
+		if err := l.Limits.validateCeiling(max); err != nil {
+			return fmt.Errorf("location %s exceeds limits: %s", l.Path, err)
+		}
+		if err := l.Limits.validateLegacy(s); err != nil {
+			return fmt.Errorf("location %s has invalid limits: %s", l.Path, err)
+		}
+	}
+	return nil
+}
+
+// Validates that the server provides the runtime the app needs and
+// permits its settings. Must be called after Validate().
+func (cfg Config) ValidateRuntime(s *server.Config) error {
+	if cfg.App.UsesInterpreter() {
+		if _, err := cfg.App.GetInterpreter(s); err != nil {
+			return err
+		}
+	}
+	if cfg.App.Kind == AppKindPHP {
+		if _, err := cfg.App.GetPHPRuntime(s); err != nil {
+			return err
+		}
+		if err := cfg.PHP.validatePermitted(s, cfg.Path); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -144,6 +401,12 @@ func (cfg Config) validateDomainsSSL() error {
 			if v.SSL.Certificate == CertSelfSigned && cfg.Context == ContextProduction {
 				return fmt.Errorf("self-signed certificates are not allowed in %s contexts, domain: %s", cfg.Context, v.FQDN)
 			}
+			if (v.SSL.Certificate == CertACME) != (v.SSL.CertificateKey == CertKeyACME) {
+				return fmt.Errorf("ACME must be used for both certificate and key, domain: %s", v.FQDN)
+			}
+			if v.SSL.Certificate == CertACME && cfg.Context == ContextDevelopment {
+				return fmt.Errorf("ACME certificates are not available in %s contexts, domain: %s", cfg.Context, v.FQDN)
+			}
 			if v.SSL.Certificate[0] != '!' && filepath.IsAbs(v.SSL.Certificate) {
 				return fmt.Errorf("certificate path is absolute, must be relative, domain: %s", v.FQDN)
 			}
@@ -157,6 +420,70 @@ func (cfg Config) validateDomainsSSL() error {
 	return nil
 }
 
+// Checks that project-supplied certificates match their key and
+// the domain's FQDN. Mismatches are only warned about in development
+// contexts. Missing files are left to be detected on install.
+func (cfg Config) validateDomainsSSLFiles() error {
+	for _, v := range cfg.Domain {
+		if !v.SSL.IsEnabled() || v.SSL.Certificate[0] == '!' || v.SSL.CertificateKey[0] == '!' {
+			continue
+		}
+		cert, err := ioutil.ReadFile(filepath.Join(cfg.Path, v.SSL.Certificate))
+		if err != nil {
+			continue
+		}
+		key, err := ioutil.ReadFile(filepath.Join(cfg.Path, v.SSL.CertificateKey))
+		if err != nil {
+			continue
+		}
+
+		if err := checkCertificate(cert, key, v.FQDN); err != nil {
+			if cfg.Context == ContextDevelopment {
+				log.Printf("warning: %s, domain: %s", err, v.FQDN)
+				continue
+			}
+			return fmt.Errorf("%s, domain: %s", err, v.FQDN)
+		}
+	}
+	return nil
+}
+
+func checkCertificate(certPEM []byte, keyPEM []byte, fqdn string) error {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("certificate does not match its key: %s", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate: %s", err)
+	}
+	if err := cert.VerifyHostname(fqdn); err != nil {
+		return fmt.Errorf("certificate does not match FQDN: %s", err)
+	}
+	return nil
+}
+
+// Secret references must name the secret they reference.
+func (cfg Config) validateSecretRefs() error {
+	check := func(v string, what string) error {
+		if _, name, ok := ParseSecretRef(v); ok && name == "" {
+			return fmt.Errorf("secret reference %s without name used for %s", v, what)
+		}
+		return nil
+	}
+	for _, v := range cfg.Domain {
+		if err := check(v.Auth.Password, "auth password of domain "+v.FQDN); err != nil {
+			return err
+		}
+	}
+	for _, db := range cfg.Database {
+		if err := check(db.Password, "password of database "+db.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Database names must be unique and users should for security reasons not
 // have an empty password (not even for dev contexts).
 func (cfg Config) validateDatabases() error {
@@ -172,8 +499,17 @@ func (cfg Config) validateDatabases() error {
 		if cfg.Context != ContextDevelopment && db.Password == "" {
 			return fmt.Errorf("user %s has empty password for database: %s", db.User, db.Name)
 		}
-		if db.User == "root" {
-			return fmt.Errorf("user %s is a MySQL restricted user", db.User)
+		switch db.GetKind() {
+		case DatabaseKindMySQL:
+			if db.User == "root" {
+				return fmt.Errorf("user %s is a MySQL restricted user", db.User)
+			}
+		case DatabaseKindPostgres:
+			if db.User == "postgres" {
+				return fmt.Errorf("user %s is a PostgreSQL restricted user", db.User)
+			}
+		default:
+			return fmt.Errorf("database %s has unknown kind: %s", db.Name, db.Kind)
 		}
 		seen[db.Name] = true
 	}
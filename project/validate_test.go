@@ -6,9 +6,19 @@
 package project
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"io/ioutil"
+	"math/big"
 	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/atelierdisko/hoi/server"
 )
 
 func setupTestPathOn(cfg *Config) {
@@ -23,6 +33,38 @@ func teardownTestPathOn(cfg *Config) {
 	// os.RemoveAll(cfg.Path)
 }
 
+// Writes a self-signed certificate for fqdn and a key into the
+// project's config/ssl directory. With mismatch, the key written does
+// not belong to the certificate.
+func writeTestCertOn(cfg *Config, fqdn string, mismatch bool) {
+	key, _ := rsa.GenerateKey(rand.Reader, 1024)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: fqdn},
+		DNSNames:     []string{fqdn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	if mismatch {
+		key, _ = rsa.GenerateKey(rand.Reader, 1024)
+	}
+	os.MkdirAll(cfg.Path+"/config/ssl", 0777)
+
+	ioutil.WriteFile(cfg.Path+"/config/ssl/example.org.crt", pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	}), 0644)
+	ioutil.WriteFile(cfg.Path+"/config/ssl/example.org.key", pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), 0600)
+}
+
 func TestValidBasicRequirements(t *testing.T) {
 	hoifile := `
 context = "prod"
@@ -841,6 +883,225 @@ domain example.org {
 	}
 }
 
+func TestValidSSLACMEInProdContext(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {
+	SSL = {
+		certificate = "!acme"
+	}
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("failed to validate ACME SSL cert in prod context: %s", err)
+	}
+}
+
+func TestInvalidSSLACMEInDevContext(t *testing.T) {
+	hoifile := `
+context = "dev"
+webroot = "app/webroot"
+domain example.org {
+	SSL = {
+		certificate = "!acme"
+		certificateKey = "!acme"
+	}
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect ACME SSL cert in dev context")
+	}
+}
+
+func TestInvalidSSLACMEWithProjectKey(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {
+	SSL = {
+		certificate = "!acme"
+		certificateKey = "example.org.key"
+	}
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect ACME SSL cert mixed with project key")
+	}
+}
+
+func TestValidSSLProjectCertificate(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {
+	SSL = {
+		certificate = "config/ssl/example.org.crt"
+		certificateKey = "config/ssl/example.org.key"
+	}
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+	writeTestCertOn(cfg, "example.org", false)
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("failed to validate matching project cert: %s", err)
+	}
+}
+
+func TestInvalidSSLProjectCertificateKeyMismatch(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {
+	SSL = {
+		certificate = "config/ssl/example.org.crt"
+		certificateKey = "config/ssl/example.org.key"
+	}
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+	writeTestCertOn(cfg, "example.org", true)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect project cert not matching its key")
+	}
+}
+
+func TestInvalidSSLProjectCertificateFQDNMismatch(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {
+	SSL = {
+		certificate = "config/ssl/example.org.crt"
+		certificateKey = "config/ssl/example.org.key"
+	}
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+	writeTestCertOn(cfg, "example.com", false)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect project cert not matching FQDN")
+	}
+}
+
+func TestValidSSLProjectCertificateMismatchInDevContext(t *testing.T) {
+	hoifile := `
+context = "dev"
+webroot = "app/webroot"
+domain example.org {
+	SSL = {
+		certificate = "config/ssl/example.org.crt"
+		certificateKey = "config/ssl/example.org.key"
+	}
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+	writeTestCertOn(cfg, "example.com", true)
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected only a warning in dev context, got: %s", err)
+	}
+}
+
+func TestValidSecretRefs(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {
+	auth = {
+		user = "preview"
+		password = "!env:PREVIEW_PASSWORD"
+	}
+}
+database example {
+	password = "!secret:db/example"
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("failed to validate secret references: %s", err)
+	}
+}
+
+func TestInvalidSecretRefWithoutName(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+database example {
+	password = "!secret:"
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect secret reference without name")
+	}
+}
+
 func TestValidDatabaseInProdContext(t *testing.T) {
 	hoifile := `
 context = "prod"
@@ -971,6 +1232,76 @@ database example {
 	}
 }
 
+func TestValidPostgresDatabase(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+database example {
+	kind = "postgres"
+	password = "s3cret"
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("failed to validate postgres database: %s", err)
+	}
+}
+
+func TestInvalidPostgresDatabaseRestrictedUser(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+database example {
+	kind = "postgres"
+	user = "postgres"
+	password = "s3cret"
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect restricted postgres user")
+	}
+}
+
+func TestInvalidDatabaseKind(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+database example {
+	kind = "oracle"
+	password = "s3cret"
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect unknown database kind")
+	}
+}
+
 func TestValidVolumePath(t *testing.T) {
 	hoifile := `
 context = "prod"
@@ -1031,3 +1362,769 @@ domain foo.test {
 		t.Fail()
 	}
 }
+
+func TestInvalidWorkerMemoryLimit(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+worker media {
+	command = "bin/process-media"
+	memory = "lots"
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect invalid memory limit")
+	}
+}
+
+func TestDecodeNumericLimits(t *testing.T) {
+	cfg, err := NewFromString(`
+worker media {
+	command = "bin/process-media"
+	tasksMax = 64
+	ioWeight = 500
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := cfg.Worker["media"].Limits
+	if l.TasksMax != 64 || l.IOWeight != 500 {
+		t.Errorf("failed to decode limits, got: %+v", l)
+	}
+
+	l.IOWeight = -1
+	if l.validate() == nil {
+		t.Error("failed to detect negative IO weight")
+	}
+}
+
+func TestLimitsAboveCeiling(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+worker media {
+	command = "bin/process-media"
+	memory = "1G"
+}
+cron reporter {
+	command = "bin/report"
+	cpuQuota = "20%"
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := server.New()
+	s.Limits.Max.Memory = "2G"
+	s.Limits.Max.CPUQuota = "50%"
+
+	if err := cfg.ValidateLimits(s); err != nil {
+		t.Errorf("failed to validate limits below ceiling: %s", err)
+	}
+
+	s.Limits.Max.Memory = "512M"
+	if cfg.ValidateLimits(s) == nil {
+		t.Error("failed to detect memory above ceiling")
+	}
+}
+
+func TestMemoryLimitOverflow(t *testing.T) {
+	if _, err := ParseMemory("16777216T"); err == nil {
+		t.Error("failed to detect overflowing memory size")
+	}
+	if _, err := ParseMemory("18446744073709551615"); err != nil {
+		t.Errorf("failed to parse maximum memory size: %s", err)
+	}
+	if v, _ := ParseMemory("16777215T"); v != 16777215<<40 {
+		t.Errorf("failed to parse large memory size, got: %d", v)
+	}
+}
+
+func TestLegacyIOWeightRange(t *testing.T) {
+	cfg, err := NewFromString(`
+worker media {
+	command = "bin/process-media"
+	ioWeight = 5000
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := server.New()
+
+	if err := cfg.ValidateLimits(s); err != nil {
+		t.Errorf("failed to accept IO weight: %s", err)
+	}
+	s.Systemd.UseLegacy = true
+	if cfg.ValidateLimits(s) == nil {
+		t.Error("failed to detect IO weight above legacy range")
+	}
+
+	w := cfg.Worker["media"]
+	w.Limits.IOWeight = 0
+	cfg.Worker["media"] = w
+	s.Limits.Default.IOWeight = 5
+	if cfg.ValidateLimits(s) == nil {
+		t.Error("failed to detect default IO weight below legacy range")
+	}
+	s.Limits.Default.IOWeight = 100
+	if err := cfg.ValidateLimits(s); err != nil {
+		t.Errorf("failed to accept IO weight within legacy range: %s", err)
+	}
+}
+
+func TestInvalidProjectCPUQuota(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+limits {
+	cpuQuota = "2"
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect invalid CPU quota")
+	}
+}
+
+func TestInvalidEnvName(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+worker media {
+	command = "bin/process-media"
+	env {
+		"CACHE-PREFIX" = "example"
+	}
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect invalid environment variable name")
+	}
+}
+
+func TestEnvFile(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+envFile = "config/production.env"
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect missing env file")
+	}
+
+	os.MkdirAll(cfg.Path+"/config", 0777)
+	ioutil.WriteFile(cfg.Path+"/config/production.env", []byte("FOO=bar\n"), 0644)
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("failed to validate existing env file: %s", err)
+	}
+}
+
+func TestBlueGreenRequiresServiceApp(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+app {
+	kind = "php"
+	useBlueGreen = true
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect blue/green deploys for PHP app")
+	}
+}
+
+func TestBlueGreenRejectsFixedPort(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+app {
+	kind = "service"
+	command = "bin/server"
+	useBlueGreen = true
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.App.Port = 8080
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Augment() == nil {
+		t.Error("failed to detect fixed port with blue/green deploys")
+	}
+}
+
+func TestInvalidAppInstances(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+app {
+	kind = "service"
+	command = "bin/server -l localhost:{{.P.App.Port}}"
+	instances = 2
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect instances sharing the port of the command")
+	}
+
+	cfg.App.Command.Command = "bin/server -l localhost:$PORT"
+	cfg.App.Balance = "random"
+	if cfg.Validate() == nil {
+		t.Error("failed to detect unknown balancing method")
+	}
+}
+
+func TestInvalidWorkerHealthCheck(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+worker "media" {
+	command = "bin/media-processor"
+	healthCheck {
+		path = "/health"
+	}
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect HTTP health check for worker")
+	}
+
+	w := cfg.Worker["media"]
+	w.HealthCheck.Path = ""
+	w.HealthCheck.Command.Command = "bin/media-processor --ping"
+	w.HealthCheck.Interval = "soon"
+	cfg.Worker["media"] = w
+	if cfg.Validate() == nil {
+		t.Error("failed to detect invalid health check interval")
+	}
+}
+
+func TestInvalidAppSocket(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+app {
+	kind = "service"
+	command = "bin/server -l unix:$SOCKET"
+	listen = "socket"
+	instances = 2
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect multiple instances sharing a socket")
+	}
+
+	cfg.App.Instances = 1
+	cfg.App.Kind = AppKindPHP
+	if cfg.Validate() == nil {
+		t.Error("failed to detect PHP app listening on a socket")
+	}
+
+	cfg.App.Kind = AppKindService
+	cfg.App.Listen = "udp"
+	if cfg.Validate() == nil {
+		t.Error("failed to detect unknown listen type")
+	}
+}
+
+func TestSocketActivationExcludesBlueGreen(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+app {
+	kind = "service"
+	command = "bin/server"
+	useSocketActivation = true
+	useBlueGreen = true
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect socket activation together with blue/green deploys")
+	}
+}
+
+func TestDetectNodeApp(t *testing.T) {
+	cfg, err := NewFromString(`context = "prod"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	ioutil.WriteFile(cfg.Path+"/package.json", []byte(`{"main": "app.js", "scripts": {"start": "node server.js --cluster"}}`), 0644)
+
+	if err := cfg.Augment(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.App.Kind != AppKindNode || !cfg.App.IsService() {
+		t.Errorf("expected node app, got: %s", cfg.App.Kind)
+	}
+	if cfg.App.Command.Command != "server.js --cluster" {
+		t.Errorf("expected entry point from start script, got: %s", cfg.App.Command.Command)
+	}
+	if cfg.Webroot != cfg.Path {
+		t.Errorf("expected project root as webroot, got: %s", cfg.Webroot)
+	}
+}
+
+func TestDetectPythonAppWithScripts(t *testing.T) {
+	cfg, err := NewFromString(`context = "prod"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	ioutil.WriteFile(cfg.Path+"/pyproject.toml", []byte("[project]\nname = \"example\"\n\n[project.scripts]\nexample = \"example.server:main\"\n"), 0644)
+
+	err = cfg.Augment()
+	if err == nil || !strings.Contains(err.Error(), "pyproject.toml") {
+		t.Errorf("expected error asking for a command, got: %v", err)
+	}
+
+	ioutil.WriteFile(cfg.Path+"/main.py", []byte(""), 0644)
+	if err := cfg.Augment(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.App.Kind != AppKindPython || cfg.App.Command.Command != "main.py" {
+		t.Errorf("expected python app with main file, got: %s, %s", cfg.App.Kind, cfg.App.Command.Command)
+	}
+}
+
+func TestValidateRuntimeSelectsInterpreter(t *testing.T) {
+	cfg, err := NewFromString(`
+app {
+	kind = "python"
+	version = "3.11.4"
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := server.NewFromString(`
+python {
+	version = "3.9"
+	interpreters = {
+		"3" = "/usr/bin/python3"
+		"3.11" = "/opt/python-3.11/bin/python3"
+	}
+}
+`)
+	if err := cfg.ValidateRuntime(s); err != nil {
+		t.Fatal(err)
+	}
+	if i, _ := cfg.App.GetInterpreter(s); i != "/opt/python-3.11/bin/python3" {
+		t.Errorf("expected most specific interpreter, got: %s", i)
+	}
+
+	cfg.App.Version = ""
+	if i, _ := cfg.App.GetInterpreter(s); i != "/usr/bin/python3" {
+		t.Errorf("expected interpreter of default version, got: %s", i)
+	}
+
+	cfg.App.Version = "2.7"
+	if cfg.ValidateRuntime(s) == nil {
+		t.Error("failed to detect missing interpreter")
+	}
+}
+
+func TestValidateRuntimeSelectsPHPRuntime(t *testing.T) {
+	cfg, err := NewFromString(`
+app {
+	kind = "php"
+	version = "7.2.5"
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := server.NewFromString(`
+PHP {
+	service = "php{{.P.App.GetMajorVersion .S}}.{{.P.App.GetMinorVersion .S}}-fpm"
+	poolPath = "/etc/php/pool.d"
+	version = "7.0.0"
+	runtime "7.0" {}
+	runtime "7.2" {
+		poolPath = "/opt/php-7.2/pool.d"
+	}
+}
+`)
+	if err := cfg.ValidateRuntime(s); err != nil {
+		t.Fatal(err)
+	}
+	if service, _ := cfg.App.GetService(cfg, s); service != "php7.2-fpm.service" {
+		t.Errorf("expected service of runtime, got: %s", service)
+	}
+	if path, _ := cfg.App.GetPoolPath(cfg, s); path != "/opt/php-7.2/pool.d" {
+		t.Errorf("expected pool path of runtime, got: %s", path)
+	}
+	if r := cfg.App.DescribeRuntime(s); r != "PHP 7.2" {
+		t.Errorf("expected runtime description, got: %s", r)
+	}
+
+	cfg.App.Version = ""
+	if path, _ := cfg.App.GetPoolPath(cfg, s); path != "/etc/php/pool.d" {
+		t.Errorf("expected pool path falling back to server's, got: %s", path)
+	}
+
+	cfg.App.Version = "5.6.0"
+	if cfg.ValidateRuntime(s) == nil {
+		t.Error("failed to detect unknown PHP version")
+	}
+}
+
+func TestInvalidPHPPool(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+app {
+	kind = "php"
+}
+php {
+	pm = "adaptive"
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if cfg.Validate() == nil {
+		t.Error("failed to detect unknown process manager")
+	}
+
+	cfg.PHP.PM = "ondemand"
+	cfg.PHP.AdminValues = map[string]string{"memory_limit": "256M\nuser = root"}
+	if cfg.Validate() == nil {
+		t.Error("failed to detect multi-line setting value")
+	}
+
+	for _, k := range []string{"open_basedir", "sys_temp_dir", "upload_tmp_dir", "upload_max_filesize", "post_max_size"} {
+		cfg.PHP.AdminValues = map[string]string{k: "/"}
+		if cfg.Validate() == nil {
+			t.Errorf("failed to detect reserved admin value %s", k)
+		}
+	}
+	cfg.PHP.AdminValues = map[string]string{"memory_limit": "256M"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("failed to accept admin value: %s", err)
+	}
+}
+
+func TestPHPSettingsArePermitted(t *testing.T) {
+	cfg, err := NewFromString(`
+app {
+	kind = "php"
+}
+php {
+	settings = {
+		memory_limit = "256M"
+		session.gc_maxlifetime = "3600"
+	}
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := server.NewFromString(`
+PHP {
+	allowSettings = ["memory_limit", "session.*", "disable_functions"]
+	denySettings = ["disable_functions"]
+}
+`)
+	if err := cfg.ValidateRuntime(s); err != nil {
+		t.Errorf("failed to permit allowed settings: %s", err)
+	}
+
+	cfg.PHP.AdminValues = map[string]string{"disable_functions": ""}
+	err = cfg.ValidateRuntime(s)
+	if err == nil || !strings.Contains(err.Error(), "disable_functions") {
+		t.Errorf("expected error naming denied setting, got: %v", err)
+	}
+
+	cfg.PHP.AdminValues = nil
+	cfg.PHP.Settings["max_input_vars"] = "5000"
+	err = cfg.ValidateRuntime(s)
+	if err == nil || !strings.Contains(err.Error(), "max_input_vars") {
+		t.Errorf("expected error naming setting not allowed, got: %v", err)
+	}
+}
+
+func TestPHPOpenBasedirIsRestricted(t *testing.T) {
+	cfg, err := NewFromString(`
+app {
+	kind = "php"
+}
+php {
+	settings = {
+		open_basedir = "/"
+	}
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+
+	s, _ := server.NewFromString(`
+PHP {
+	allowSettings = ["open_basedir"]
+	allowOpenBasedir = ["/usr/share/php"]
+}
+`)
+	if cfg.ValidateRuntime(s) == nil {
+		t.Error("failed to detect open_basedir escaping the sandbox")
+	}
+
+	os.MkdirAll(cfg.Path+"/vendor", 0777)
+	os.Symlink("/", cfg.Path+"/root")
+
+	tests := []struct {
+		paths    string
+		expected bool
+	}{
+		{"/usr/share/php", true},
+		{"/usr/share/php/PEAR", true},
+		{cfg.Path + "/vendor", true},
+		{"/usr/share/php:" + cfg.Path + "/vendor", true},
+		{"/usr/share/php-other", false},
+		{"/usr/share/php/../..", false},
+		{cfg.Path + "/../other", false},
+		{cfg.Path + "/root", false},
+		{cfg.Path + "/vendor:/etc", false},
+	}
+	for _, test := range tests {
+		cfg.PHP.Settings["open_basedir"] = test.paths
+
+		err := cfg.ValidateRuntime(s)
+		if (err == nil) != test.expected {
+			t.Errorf("for %s result: %v | expected: %v", test.paths, err, test.expected)
+		}
+	}
+}
+
+func TestInvalidUpstreamApp(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+app {
+	kind = "upstream"
+}
+proxy {
+	urls = ["https://10.0.0.5:8443", "https://10.0.0.6"]
+	verifyTLS = true
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Proxy.URLs = []string{"https://10.0.0.5:8443", "http://10.0.0.6"}
+	if cfg.Validate() == nil {
+		t.Error("failed to detect mixed schemes")
+	}
+
+	cfg.Proxy.URLs = []string{"https://10.0.0.5:8443/api"}
+	if cfg.Validate() == nil {
+		t.Error("failed to detect URL with path")
+	}
+
+	cfg.Proxy.URLs = []string{"https://10.0.0.5;return:8443"}
+	if cfg.Validate() == nil {
+		t.Error("failed to detect URL with invalid host")
+	}
+
+	cfg.Proxy.URLs = []string{"http://10.0.0.5:8080"}
+	if cfg.Validate() == nil {
+		t.Error("failed to detect TLS options for plain HTTP upstream")
+	}
+
+	cfg.Proxy.VerifyTLS = false
+	cfg.App.Command.Command = "bin/server"
+	if cfg.Validate() == nil {
+		t.Error("failed to detect upstream app with command")
+	}
+
+	cfg.App.Command.Command = ""
+	cfg.Proxy.SetHeaders = map[string]string{"X-Foo": "bar\"; more"}
+	if cfg.Validate() == nil {
+		t.Error("failed to detect invalid header value")
+	}
+
+	cfg.Proxy.SetHeaders = nil
+	cfg.Proxy.URLs = nil
+	if cfg.Validate() == nil {
+		t.Error("failed to detect upstream app without URLs")
+	}
+
+	cfg.App.Kind = AppKindStatic
+	cfg.Proxy.URLs = []string{"http://10.0.0.5:8080"}
+	if cfg.Validate() == nil {
+		t.Error("failed to detect proxying for static app")
+	}
+}
+
+func TestInvalidLocation(t *testing.T) {
+	hoifile := `
+context = "prod"
+webroot = "app/webroot"
+domain example.org {}
+app {
+	kind = "static"
+}
+location "/docs" {
+	kind = "static"
+	root = "docs"
+}
+`
+	cfg, err := NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupTestPathOn(cfg)
+	defer teardownTestPathOn(cfg)
+	os.MkdirAll(cfg.Path+"/app/webroot", 0777)
+	os.MkdirAll(cfg.Path+"/docs", 0777)
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	docs := cfg.Location["/docs"]
+	docs.Root = "../other"
+	cfg.Location["/docs"] = docs
+	if cfg.Validate() == nil {
+		t.Error("failed to detect root outside of project")
+	}
+
+	docs.Root = "docs"
+	docs.Kind = AppKindPHP
+	cfg.Location["/docs"] = docs
+	if cfg.Validate() == nil {
+		t.Error("failed to detect PHP location without PHP app")
+	}
+
+	docs.Kind = AppKindService
+	docs.Root = ""
+	cfg.Location["/docs"] = docs
+	if cfg.Validate() == nil {
+		t.Error("failed to detect service location without command")
+	}
+
+	docs.Command.Command = "bin/docs"
+	cfg.Location["/docs"] = docs
+	cfg.Location["/docs/v1"] = LocationDirective{Path: "/docs/v1", Kind: AppKindService, Command: Command{"bin/v1"}}
+	cfg.Location["/docs-v1"] = LocationDirective{Path: "/docs-v1", Kind: AppKindService, Command: Command{"bin/v1"}}
+	if cfg.Validate() == nil {
+		t.Error("failed to detect locations sharing an ID")
+	}
+
+	delete(cfg.Location, "/docs/v1")
+	delete(cfg.Location, "/docs-v1")
+	cfg.Location["/api/"] = LocationDirective{Path: "/api/", Kind: AppKindService, Command: Command{"bin/api"}}
+	if cfg.Validate() == nil {
+		t.Error("failed to detect path with trailing slash")
+	}
+}
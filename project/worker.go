@@ -27,6 +27,12 @@ type WorkerDirective struct {
 	// Commands will be executed with the project root path as the current working
 	// directory.
 	Command `hcl:",squash"`
+	// Resource limits for the worker's processes.
+	Limits `hcl:",squash"`
+	// Environment variables for the worker's processes.
+	Environment `hcl:",squash"`
+	// Checks whether the worker is able to process jobs.
+	HealthCheck HealthCheckDirective
 }
 
 // Generates the ID for the directive, prefers the plain Name, if that
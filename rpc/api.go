@@ -13,24 +13,35 @@ import (
 )
 
 type ProjectAPI struct {
-	StatusHandler    func(path string) (store.Entity, error)
-	StatusAllHandler func() ([]store.Entity, error)
+	StatusHandler    func(path string, showSecrets bool) (store.Entity, error)
+	StatusAllHandler func(showSecrets bool) ([]store.Entity, error)
+	UnitsHandler     func(path string) ([]UnitReport, error)
 	LoadHandler      func(path string) error
+	PlanHandler      func(path string) (string, error)
 	UnloadHandler    func(path string) error
 	UnloadAllHandler func() error
 	ReloadHandler    func(path string) error
 	ReloadAllHandler func() error
 	DomainHandler    func(path string, dDrv *project.DomainDirective) error
 	DumpHandler      func(path string, target string) error
+	RestoreHandler   func(path string, source string) error
+	CertsHandler     func(path string) ([]CertStatus, error)
+	CertsAllHandler  func() ([]CertStatus, error)
 }
 
-func (p *ProjectAPI) Status(args *ProjectAPIArgs, reply *store.Entity) error {
-	data, err := p.StatusHandler(args.Path)
+func (p *ProjectAPI) Status(args *StatusAPIArgs, reply *store.Entity) error {
+	data, err := p.StatusHandler(args.Path, args.ShowSecrets)
 	*reply = data
 	return logIfError(err)
 }
-func (p *ProjectAPI) StatusAll(args *ProjectAPIArgs, reply *[]store.Entity) error {
-	data, err := p.StatusAllHandler()
+func (p *ProjectAPI) StatusAll(args *StatusAPIArgs, reply *[]store.Entity) error {
+	data, err := p.StatusAllHandler(args.ShowSecrets)
+	*reply = data
+	return logIfError(err)
+}
+
+func (p *ProjectAPI) Units(args *ProjectAPIArgs, reply *[]UnitReport) error {
+	data, err := p.UnitsHandler(args.Path)
 	*reply = data
 	return logIfError(err)
 }
@@ -38,6 +49,12 @@ func (p *ProjectAPI) StatusAll(args *ProjectAPIArgs, reply *[]store.Entity) erro
 func (p *ProjectAPI) Load(args *ProjectAPIArgs, reply *bool) error {
 	return logIfError(p.LoadHandler(args.Path))
 }
+func (p *ProjectAPI) Plan(args *ProjectAPIArgs, reply *string) error {
+	data, err := p.PlanHandler(args.Path)
+	*reply = data
+	return logIfError(err)
+}
+
 func (p *ProjectAPI) Unload(args *ProjectAPIArgs, reply *bool) error {
 	return logIfError(p.UnloadHandler(args.Path))
 }
@@ -60,6 +77,21 @@ func (p *ProjectAPI) Dump(args *DumpAPIArgs, reply *bool) error {
 	return logIfError(p.DumpHandler(args.Path, args.File))
 }
 
+func (p *ProjectAPI) Restore(args *DumpAPIArgs, reply *bool) error {
+	return logIfError(p.RestoreHandler(args.Path, args.File))
+}
+
+func (p *ProjectAPI) Certs(args *ProjectAPIArgs, reply *[]CertStatus) error {
+	data, err := p.CertsHandler(args.Path)
+	*reply = data
+	return logIfError(err)
+}
+func (p *ProjectAPI) CertsAll(args *ProjectAPIArgs, reply *[]CertStatus) error {
+	data, err := p.CertsAllHandler()
+	*reply = data
+	return logIfError(err)
+}
+
 func logIfError(err error) error {
 	if err != nil {
 		log.Print(err)
@@ -12,6 +12,13 @@ type ProjectAPIArgs struct {
 	Path string
 }
 
+type StatusAPIArgs struct {
+	Path string
+	// Whether to include plaintext secrets in the reply, instead of
+	// masking them. Secret references are never resolved.
+	ShowSecrets bool
+}
+
 type DomainAPIArgs struct {
 	Path   string
 	Domain *project.DomainDirective
@@ -0,0 +1,63 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import "time"
+
+// Describes the certificate installed for a project's domain.
+type CertStatus struct {
+	ProjectID   string
+	ProjectName string
+	FQDN        string
+	Subject     string
+	Issuer      string
+	// DNS names the certificate is valid for.
+	Names    []string
+	NotAfter time.Time
+	// Whether the certificate expires within the configured warning
+	// window, or has already expired.
+	Expiring bool
+	// Names the domain is served under, which the certificate does
+	// not cover.
+	Uncovered []string
+	// Set if the certificate could not be inspected, i.e. because it
+	// is not installed.
+	Error string
+}
+
+// Checks whether any problems were detected with the certificate.
+func (c CertStatus) HasProblems() bool {
+	return c.Expiring || len(c.Uncovered) > 0 || c.Error != ""
+}
+
+// Describes the live status of a single unit installed for a project,
+// see runner.UnitReport.
+type UnitReport struct {
+	// The hoi-internal kind of unit, i.e. "worker".
+	Kind string
+	// Name of the directive the unit belongs to, i.e. the name of a
+	// worker; empty for the app service.
+	Directive string
+	Status    UnitStatus
+	// Set if the status could not be retrieved, i.e. because the unit
+	// is not loaded.
+	Error string
+}
+
+// The live status of a unit as reported by systemd, see
+// system.UnitStatus.
+type UnitStatus struct {
+	Unit        string
+	ActiveState string
+	SubState    string
+	MainPID     uint32
+	Memory      uint64
+	CPUUsage    time.Duration
+	Restarts    uint32
+	LastTrigger time.Time
+	NextTrigger time.Time
+	Result      string
+}
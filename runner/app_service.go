@@ -6,7 +6,12 @@
 package runner
 
 import (
+	"fmt"
+	"net"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/atelierdisko/hoi/builder"
 	"github.com/atelierdisko/hoi/project"
@@ -24,6 +29,23 @@ func NewAppServiceRunner(s *server.Config, p *project.Config, conn *systemd.Conn
 	}
 }
 
+// The unit templates all instances of the service and - with socket
+// activation - their sockets are started from.
+const (
+	appServiceTemplate       = "default@.service"
+	appServiceSocketTemplate = "default@.socket"
+)
+
+// Runs the service of service apps. Each instance of the service is
+// started from the same unit template, instances are named after the
+// port they listen on, i.e. "default@8080.service". With blue/green
+// deploys, this allows the instances of two generations to run side
+// by side. A service listening on a unix socket has a single
+// instance, named "default@socket.service".
+//
+// With socket activation, each instance has a socket unit of the same
+// name, i.e. "default@8080.socket", which is started in place of the
+// service.
 type AppServiceRunner struct {
 	s     *server.Config
 	p     *project.Config
@@ -32,6 +54,16 @@ type AppServiceRunner struct {
 }
 
 func (r AppServiceRunner) Disable() error {
+	// Stop sockets first, so they don't start services again.
+	sockets, err := r.sys.ListInstalledSockets()
+	if err != nil {
+		return err
+	}
+	for _, uS := range sockets {
+		if err := r.sys.StopAndDisable(uS); err != nil {
+			return err
+		}
+	}
 	services, err := r.sys.ListInstalledServices()
 	if err != nil {
 		return err
@@ -40,10 +72,22 @@ func (r AppServiceRunner) Disable() error {
 		if err := r.sys.StopAndDisable(uS); err != nil {
 			return err
 		}
-		if err := r.sys.Uninstall(uS); err != nil {
-			return err
+		// Instances don't have a file backing them, but units not
+		// started from the template do.
+		if !strings.Contains(uS, "@") {
+			if err := r.sys.Uninstall(uS); err != nil {
+				return err
+			}
 		}
 	}
+	// The project may have listened on a socket before.
+	if err := os.RemoveAll(filepath.Dir(r.p.App.GetSocket(r.p, r.s))); err != nil {
+		return err
+	}
+
+	if err := r.uninstallTemplates(); err != nil {
+		return err
+	}
 	return r.build.Clean()
 }
 
@@ -51,39 +95,355 @@ func (r AppServiceRunner) Enable() error {
 	if !r.p.App.HasCommand() {
 		return nil // nothing to do
 	}
+	if err := r.install(); err != nil {
+		return err
+	}
+	for _, id := range r.instances() {
+		if err := r.sys.EnableAndStart(r.startUnit(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r AppServiceRunner) Plan(scratch string) ([]Change, error) {
+	b := r.build.Scratch(scratch)
+
+	if r.p.App.HasCommand() {
+		if err := r.buildFiles(b); err != nil {
+			return nil, err
+		}
+	}
+	built, err := b.ListAvailable()
+	if err != nil {
+		return nil, err
+	}
+	installed, err := r.sys.ListInstalledFiles()
+	if err != nil {
+		return nil, err
+	}
+	return planFiles(built, installed, r.sys.InstallPath), nil
+}
+
+func (r AppServiceRunner) buildFiles(b *builder.Builder) error {
+	templates := []string{appServiceTemplate}
+
+	var interpreter string
+	if r.p.App.UsesInterpreter() {
+		i, err := r.p.App.GetInterpreter(r.s)
+		if err != nil {
+			return err
+		}
+		interpreter = i
+	}
+	var listenHost string
+	if r.p.App.UseSocketActivation {
+		templates = append(templates, appServiceSocketTemplate)
 
-	tS, err := r.build.LoadTemplate("default.service")
+		if !r.p.App.UsesSocket() {
+			host, err := r.listenHost()
+			if err != nil {
+				return err
+			}
+			listenHost = host
+		}
+	}
 	tmplData := struct {
-		P *project.Config
-		S *server.Config
+		P           *project.Config
+		S           *server.Config
+		EnvFiles    []string
+		Socket      string
+		ListenHost  string
+		Interpreter string
 	}{
-		P: r.p,
-		S: r.s,
-	}
-	err = r.build.WriteTemplate(
-		"default.service",
-		tS,
-		tmplData,
-	)
+		P:           r.p,
+		S:           r.s,
+		EnvFiles:    envFiles(r.s, r.p, builder.KindAppService, ""),
+		Socket:      r.p.App.GetSocket(r.p, r.s),
+		ListenHost:  listenHost,
+		Interpreter: interpreter,
+	}
+	for _, name := range templates {
+		t, err := b.LoadTemplate(name)
+		if err != nil {
+			return err
+		}
+		if err := b.WriteTemplate(name, t, tmplData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the IP address of the host to listen on in a form suitable
+// for ListenStream=, which doesn't accept host names.
+func (r AppServiceRunner) listenHost() (string, error) {
+	ips, err := net.LookupIP(r.p.App.Host)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to resolve app host %s: %s", r.p.App.Host, err)
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
 	}
+	return "[" + ips[0].String() + "]", nil
+}
 
-	files, err := r.build.ListAvailable()
+// Builds and installs the unit templates, replacing any previously
+// installed ones. Running instances are not affected.
+func (r AppServiceRunner) install() error {
+	if r.p.App.UsesSocket() {
+		if err := r.prepareSocket(); err != nil {
+			return err
+		}
+	}
+	if err := r.build.Clean(); err != nil {
+		return err
+	}
+	if err := r.buildFiles(r.build); err != nil {
+		return err
+	}
+	built, err := r.build.ListAvailable()
 	if err != nil {
 		return err
 	}
-	for _, f := range files {
+	keep := make([]string, 0, len(built))
+	for _, f := range built {
 		if err := r.sys.Install(f); err != nil {
 			return err
 		}
-		if err := r.sys.EnableAndStart(filepath.Base(f)); err != nil {
+		keep = append(keep, filepath.Base(f))
+	}
+	if err := r.uninstallTemplates(keep...); err != nil {
+		return err
+	}
+	return r.sys.ReloadIfDirty()
+}
+
+// Uninstalls the installed unit templates, except the given ones.
+func (r AppServiceRunner) uninstallTemplates(keep ...string) error {
+	files, err := r.sys.ListInstalledFiles()
+	if err != nil {
+		return err
+	}
+	installed := make(map[string]bool, len(files))
+	for _, f := range files {
+		installed[f] = true
+	}
+	for _, t := range keep {
+		installed[r.sys.InstallPath(t)] = false
+	}
+	for _, t := range []string{appServiceTemplate, appServiceSocketTemplate} {
+		if !installed[r.sys.InstallPath(t)] {
+			continue
+		}
+		if err := r.sys.Uninstall(t); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// Creates the directory holding the socket, owned by the user the
+// service runs as, so that it can create the socket. NGINX must run
+// as the same user or group to connect to it.
+func (r AppServiceRunner) prepareSocket() error {
+	dir := filepath.Dir(r.p.App.GetSocket(r.p, r.s))
+
+	cred, err := lookupCredential(r.s.User, r.s.Group)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, 0750); err != nil {
+		return err
+	}
+	return os.Chown(dir, int(cred.Uid), int(cred.Gid))
+}
+
+// Returns the IDs of the instances to run: their ports or, when
+// listening on a socket, just "socket".
+func (r AppServiceRunner) instances() []string {
+	if r.p.App.UsesSocket() {
+		return []string{"socket"}
+	}
+	ids := make([]string, 0, len(r.p.App.GetPorts()))
+	for _, port := range r.p.App.GetPorts() {
+		ids = append(ids, strconv.Itoa(int(port)))
+	}
+	return ids
+}
+
+// Returns the unprefixed name of the instance with the given ID.
+func (r AppServiceRunner) instance(id string) string {
+	return strings.Replace(appServiceTemplate, "@.", "@"+id+".", 1)
+}
+
+// Returns the unprefixed name of the socket of the instance with the
+// given ID.
+func (r AppServiceRunner) socketInstance(id string) string {
+	return strings.Replace(appServiceSocketTemplate, "@.", "@"+id+".", 1)
+}
+
+// Returns the unit to start for the instance with the given ID: its
+// socket with socket activation, the service itself otherwise.
+func (r AppServiceRunner) startUnit(id string) string {
+	if r.p.App.UseSocketActivation {
+		return r.socketInstance(id)
+	}
+	return r.instance(id)
+}
+
+// Starts the instances next to the ones currently running, and waits
+// until they pass their health check. If one doesn't, the new
+// instances are stopped again, leaving the current ones untouched.
+//
+// With socket activation, the sockets of the instances are started
+// and running instances listening on them are restarted; systemd
+// holds back connections meanwhile. Otherwise the service is simply
+// restarted.
+func (r AppServiceRunner) Deploy() error {
+	if r.p.App.UseSocketActivation && r.p.App.HasCommand() {
+		if err := r.install(); err != nil {
+			return err
+		}
+		for _, id := range r.instances() {
+			if err := r.sys.EnableAndStart(r.socketInstance(id)); err != nil {
+				return err
+			}
+			if err := r.sys.TryRestart(r.instance(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !r.p.App.UseBlueGreen {
+		if err := r.Disable(); err != nil {
+			return err
+		}
+		return r.Enable()
+	}
+	if !r.p.App.HasCommand() {
+		return nil
+	}
+	if err := r.install(); err != nil {
+		return err
+	}
+
+	var err error
+	started := make([]string, 0)
+
+	for _, id := range r.instances() {
+		if err = r.sys.EnableAndStart(r.instance(id)); err != nil {
+			break
+		}
+		started = append(started, id)
+
+		if err = r.check(id).Wait(); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	for _, id := range started {
+		if sErr := r.sys.StopAndDisable(r.instance(id)); sErr != nil {
+			return fmt.Errorf("%s; failed to stop new service: %s", err, sErr)
+		}
+	}
+	return err
+}
+
+// Stops all instances but the current ones. Must only be called once
+// the web server has been switched over to the current instances.
+func (r AppServiceRunner) Retire() error {
+	if !r.p.App.UseBlueGreen && !r.p.App.UseSocketActivation {
+		return nil
+	}
+	current := make(map[string]bool)
+	for _, id := range r.instances() {
+		current[r.instance(id)] = true
+		current[r.socketInstance(id)] = r.p.App.UseSocketActivation
+	}
+
+	sockets, err := r.sys.ListInstalledSockets()
+	if err != nil {
+		return err
+	}
+	for _, uS := range sockets {
+		if current[uS] {
+			continue
+		}
+		if err := r.sys.StopAndDisable(uS); err != nil {
+			return err
+		}
+	}
+	services, err := r.sys.ListInstalledServices()
+	if err != nil {
+		return err
+	}
+	for _, uS := range services {
+		if current[uS] {
+			continue
+		}
+		if err := r.sys.StopAndDisable(uS); err != nil {
+			return err
+		}
+		if !strings.Contains(uS, "@") {
+			if err := r.sys.Uninstall(uS); err != nil {
+				return err
+			}
+		}
+	}
+	return r.sys.ReloadIfDirty()
+}
+
+// Returns the health check of the instance with the given ID.
+func (r AppServiceRunner) check(id string) Check {
+	if r.p.App.UsesSocket() {
+		return httpCheck("app", r.p.App.HealthCheck, "unix", r.p.App.GetSocket(r.p, r.s))
+	}
+	name := "app"
+	if len(r.p.App.GetPorts()) > 1 {
+		name = fmt.Sprintf("app@%s", id)
+	}
+	return httpCheck(name, r.p.App.HealthCheck, "tcp", net.JoinHostPort(r.p.App.Host, id))
+}
+
+// Returns a health check for each instance, if the app has one.
+func (r AppServiceRunner) Checks() []Check {
+	checks := make([]Check, 0)
+
+	if r.p.App.HasCommand() && r.p.App.HealthCheck.IsEnabled() {
+		for _, id := range r.instances() {
+			checks = append(checks, r.check(id))
+		}
+	}
+	return checks
+}
+
+func (r AppServiceRunner) Wait() error {
+	return waitChecks(r.Checks())
+}
+
 func (r AppServiceRunner) Commit() error {
 	return r.sys.ReloadIfDirty()
 }
+
+// Reports the status of each instance.
+func (r AppServiceRunner) Status() ([]UnitReport, error) {
+	reports := make([]UnitReport, 0)
+
+	if r.p.App.HasCommand() {
+		for _, id := range r.instances() {
+			if r.p.App.UseSocketActivation {
+				reports = append(reports, reportUnit(r.sys, system.SystemdKindAppService, "", r.socketInstance(id)))
+			}
+			reports = append(reports, reportUnit(r.sys, system.SystemdKindAppService, "", r.instance(id)))
+		}
+	}
+	return reports, nil
+}
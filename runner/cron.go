@@ -68,25 +68,66 @@ func (r CronRunner) Disable() error {
 }
 
 func (r CronRunner) Enable() error {
-	tS, err := r.build.LoadTemplate("default.service")
+	if err := r.buildFiles(r.build); err != nil {
+		return err
+	}
+
+	files, err := r.build.ListAvailable()
 	if err != nil {
 		return err
 	}
-	tT, err := r.build.LoadTemplate("default.timer")
+	for _, f := range files {
+		if err := r.sys.Install(f); err != nil {
+			return err
+		}
+		if strings.HasSuffix(f, ".timer") {
+			if err := r.sys.EnableAndStart(filepath.Base(f)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r CronRunner) Plan(scratch string) ([]Change, error) {
+	b := r.build.Scratch(scratch)
+
+	if err := r.buildFiles(b); err != nil {
+		return nil, err
+	}
+	built, err := b.ListAvailable()
+	if err != nil {
+		return nil, err
+	}
+	installed, err := r.sys.ListInstalledFiles()
+	if err != nil {
+		return nil, err
+	}
+	return planFiles(built, installed, r.sys.InstallPath), nil
+}
+
+func (r CronRunner) buildFiles(b *builder.Builder) error {
+	tS, err := b.LoadTemplate("default.service")
+	if err != nil {
+		return err
+	}
+	tT, err := b.LoadTemplate("default.timer")
 	if err != nil {
 		return err
 	}
 	for _, v := range r.p.Cron {
 		tmplData := struct {
-			P *project.Config
-			S *server.Config
-			C project.CronDirective
+			P        *project.Config
+			S        *server.Config
+			C        project.CronDirective
+			EnvFiles []string
 		}{
-			P: r.p,
-			S: r.s,
-			C: v,
+			P:        r.p,
+			S:        r.s,
+			C:        v,
+			EnvFiles: envFiles(r.s, r.p, builder.KindCron, v.GetID()),
 		}
-		err = r.build.WriteTemplate(
+		err = b.WriteTemplate(
 			fmt.Sprintf("%s.service", v.GetID()),
 			tS,
 			tmplData,
@@ -94,7 +135,7 @@ func (r CronRunner) Enable() error {
 		if err != nil {
 			return err
 		}
-		err = r.build.WriteTemplate(
+		err = b.WriteTemplate(
 			fmt.Sprintf("%s.timer", v.GetID()),
 			tT,
 			tmplData,
@@ -103,24 +144,20 @@ func (r CronRunner) Enable() error {
 			return err
 		}
 	}
-
-	files, err := r.build.ListAvailable()
-	if err != nil {
-		return err
-	}
-	for _, f := range files {
-		if err := r.sys.Install(f); err != nil {
-			return err
-		}
-		if strings.HasSuffix(f, ".timer") {
-			if err := r.sys.EnableAndStart(filepath.Base(f)); err != nil {
-				return err
-			}
-		}
-	}
 	return nil
 }
 
 func (r CronRunner) Commit() error {
 	return r.sys.ReloadIfDirty()
 }
+
+// Reports the status of the timers, which includes the result of
+// the last run.
+func (r CronRunner) Status() ([]UnitReport, error) {
+	reports := make([]UnitReport, 0, len(r.p.Cron))
+
+	for _, c := range r.p.Cron {
+		reports = append(reports, reportUnit(r.sys, system.SystemdKindCron, c.GetID(), c.GetID()+".timer"))
+	}
+	return reports, nil
+}
@@ -8,9 +8,14 @@ package runner
 import (
 	"archive/tar"
 	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"path"
 	"strings"
 
 	"github.com/atelierdisko/hoi/project"
+	"github.com/atelierdisko/hoi/secret"
 	"github.com/atelierdisko/hoi/server"
 	"github.com/atelierdisko/hoi/system"
 )
@@ -24,29 +29,59 @@ const (
 	// The minimum set of database level privileges for migrating
 	// the database in use by the project.
 	DBAdminPrivs = "LOCK TABLES,ALTER,DROP,CREATE,INDEX"
+
+	// PostgreSQL grants privileges on tables to their owner, which is
+	// the user creating them. The user is thus only allowed to
+	// connect and create objects, see system.PostgreSQL.EnsureGrant().
+	PostgresDBPrivs      = "CONNECT,TEMPORARY"
+	PostgresDBAdminPrivs = "CREATE"
 )
 
-func NewDBRunner(s *server.Config, p *project.Config, conn *sql.DB) *DBRunner {
+// Connections may be nil, if the corresponding database server is
+// not used.
+func NewDBRunner(s *server.Config, p *project.Config, mysqlConn *sql.DB, postgresConn *sql.DB) *DBRunner {
 	return &DBRunner{
-		s:   s,
-		p:   p,
-		sys: system.NewMySQL(p, s, conn),
+		s:        s,
+		p:        p,
+		mysql:    system.NewMySQL(p, s, mysqlConn),
+		postgres: system.NewPostgreSQL(p, s, postgresConn),
+		secrets:  secret.NewResolver(s, p),
 	}
 }
 
 // Ensures that database and user for the project are available
 // and the user has a minimum set of privileges assigned to her.
+// Databases may be kept on MySQL or PostgreSQL servers.
 type DBRunner struct {
-	s   *server.Config
-	p   *project.Config
-	sys *system.MySQL
+	s        *server.Config
+	p        *project.Config
+	mysql    *system.MySQL
+	postgres *system.PostgreSQL
+	secrets  *secret.Resolver
 }
 
-func (r DBRunner) Disable() error {
-	privs := strings.Split(DBPrivs+","+DBAdminPrivs, ",")
+// Returns the database server and the set of privileges to grant for
+// the database.
+func (r DBRunner) sys(db project.DatabaseDirective) (system.DB, []string, error) {
+	switch db.GetKind() {
+	case project.DatabaseKindMySQL:
+		return r.mysql, strings.Split(DBPrivs+","+DBAdminPrivs, ","), nil
+	case project.DatabaseKindPostgres:
+		if !r.s.PostgreSQL.IsEnabled() {
+			return nil, nil, fmt.Errorf("database %s needs PostgreSQL, but no server has been configured", db.Name)
+		}
+		return r.postgres, strings.Split(PostgresDBPrivs+","+PostgresDBAdminPrivs, ","), nil
+	}
+	return nil, nil, fmt.Errorf("database %s has unknown kind: %s", db.Name, db.Kind)
+}
 
+func (r DBRunner) Disable() error {
 	for _, db := range r.p.Database {
-		if err := r.sys.EnsureNoGrant(db.User, db.Name, privs); err != nil {
+		sys, privs, err := r.sys(db)
+		if err != nil {
+			return err
+		}
+		if err := sys.EnsureNoGrant(db.User, db.Name, privs); err != nil {
 			return err
 		}
 	}
@@ -54,16 +89,22 @@ func (r DBRunner) Disable() error {
 }
 
 func (r DBRunner) Enable() error {
-	privs := strings.Split(DBPrivs+","+DBAdminPrivs, ",")
-
 	for _, db := range r.p.Database {
-		if err := r.sys.EnsureDatabase(db.Name); err != nil {
+		sys, privs, err := r.sys(db)
+		if err != nil {
+			return err
+		}
+		if err := sys.EnsureDatabase(db.Name); err != nil {
 			return err
 		}
-		if err := r.sys.EnsureUser(db.User, db.Password); err != nil {
+		password, err := r.secrets.Resolve(db.Password)
+		if err != nil {
 			return err
 		}
-		if err := r.sys.EnsureGrant(db.User, db.Name, privs); err != nil {
+		if err := sys.EnsureUser(db.User, password); err != nil {
+			return err
+		}
+		if err := sys.EnsureGrant(db.User, db.Name, privs); err != nil {
 			return err
 		}
 	}
@@ -71,14 +112,120 @@ func (r DBRunner) Enable() error {
 }
 
 func (r DBRunner) Commit() error {
-	return r.sys.ReloadIfDirty()
+	for _, db := range r.p.Database {
+		sys, _, err := r.sys(db)
+		if err != nil {
+			return err
+		}
+		if err := sys.ReloadIfDirty(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Plans the statements that would be issued against the database
+// server, passwords are masked.
+func (r DBRunner) Plan(scratch string) ([]Change, error) {
+	changes := make([]Change, 0)
+
+	stmts := make([]string, 0)
+	for _, db := range r.p.Database {
+		sys, privs, err := r.sys(db)
+		if err != nil {
+			return changes, err
+		}
+		dstmts, err := sys.PlanEnsureDatabase(db.Name)
+		if err != nil {
+			return changes, err
+		}
+		stmts = append(stmts, dstmts...)
+
+		password, err := r.secrets.Resolve(db.Password)
+		if err != nil {
+			return changes, err
+		}
+		ustmts, err := sys.PlanEnsureUser(db.User, password)
+		if err != nil {
+			return changes, err
+		}
+		stmts = append(stmts, ustmts...)
+
+		gstmts, err := sys.PlanEnsureGrant(db.User, db.Name, privs)
+		if err != nil {
+			return changes, err
+		}
+		stmts = append(stmts, gstmts...)
+	}
+	for _, stmt := range stmts {
+		changes = append(changes, Change{Note: stmt})
+	}
+	return changes, nil
 }
 
 func (r DBRunner) Dump(tw *tar.Writer) error {
 	for _, db := range r.p.Database {
-		if err := r.sys.DumpDatabase(db.Name, tw); err != nil {
+		sys, _, err := r.sys(db)
+		if err != nil {
+			return err
+		}
+		if err := sys.DumpDatabase(db.Name, tw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restores databases from a dump. Database dump entries are mapped
+// to the project's databases by name. As database names differ
+// between contexts (i.e. "example" vs. "example_stage"), a project
+// with just a single database will receive any single database
+// entry, regardless of its name.
+func (r DBRunner) Restore(tr *tar.Reader) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(header.Name, "database/") || path.Ext(header.Name) != ".sql" {
+			continue
+		}
+		name := strings.TrimSuffix(path.Base(header.Name), ".sql")
+
+		db, err := r.lookupDatabase(name)
+		if err != nil {
+			return err
+		}
+		log.Printf("restoring database %s from %s", db.Name, header.Name)
+
+		sys, _, err := r.sys(db)
+		if err != nil {
+			return err
+		}
+		if err := sys.EnsureDatabase(db.Name); err != nil {
+			return err
+		}
+		if err := sys.RestoreDatabase(db.Name, db.User, tr); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// Finds the database a dumped database should be restored into.
+func (r DBRunner) lookupDatabase(name string) (project.DatabaseDirective, error) {
+	for _, db := range r.p.Database {
+		if db.Name == name {
+			return db, nil
+		}
+	}
+	if len(r.p.Database) == 1 {
+		for _, db := range r.p.Database {
+			return db, nil
+		}
+	}
+	return project.DatabaseDirective{}, fmt.Errorf("failed to find target database for dumped database %s", name)
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/atelierdisko/hoi/project"
+	"github.com/atelierdisko/hoi/server"
+)
+
+// While waiting for a check to pass, it is repeated at most this
+// often.
+const waitInterval = time.Second
+
+// A single health check, as configured by a HealthCheckDirective.
+type Check struct {
+	// Identifies the check within the project, i.e. "app" or
+	// "worker media".
+	Name        string
+	Interval    time.Duration
+	GracePeriod time.Duration
+	// Runs the check once; returns nil if the check passed.
+	Run func() error
+}
+
+// Repeats the check until it passes or its grace period is over.
+func (c Check) Wait() error {
+	interval := c.Interval
+	if interval > waitInterval {
+		interval = waitInterval
+	}
+	deadline := time.Now().Add(c.GracePeriod)
+
+	for {
+		err := c.Run()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("health check %s did not pass within %s: %s", c.Name, c.GracePeriod, err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Waits for all checks, one after another.
+func waitChecks(checks []Check) error {
+	for _, c := range checks {
+		if err := c.Wait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns a check requesting the health check's path from the
+// service listening on addr; network is either "tcp", with addr
+// being host and port, or "unix", with addr being the socket path.
+func httpCheck(name string, hc project.HealthCheckDirective, network string, addr string) Check {
+	url := "http://" + addr + hc.GetPath()
+
+	// Checks are created often, don't keep connections around.
+	transport := &http.Transport{DisableKeepAlives: true}
+	if network == "unix" {
+		url = "http://localhost" + hc.GetPath()
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	client := &http.Client{Timeout: hc.GetTimeout(), Transport: transport}
+
+	return Check{
+		Name:        name,
+		Interval:    hc.GetInterval(),
+		GracePeriod: hc.GetGracePeriod(),
+		Run: func() error {
+			res, err := client.Get(url)
+			if err != nil {
+				return err
+			}
+			res.Body.Close()
+
+			if !hc.IsExpectedStatus(res.StatusCode) {
+				return fmt.Errorf("%s responded with unexpected status %d", url, res.StatusCode)
+			}
+			return nil
+		},
+	}
+}
+
+// Returns a check running the health check's command as the server's
+// user inside the project root.
+func commandCheck(name string, hc project.HealthCheckDirective, p *project.Config, s *server.Config) Check {
+	return Check{
+		Name:        name,
+		Interval:    hc.GetInterval(),
+		GracePeriod: hc.GetGracePeriod(),
+		Run: func() error {
+			command, err := hc.GetCommand(p)
+			if err != nil {
+				return err
+			}
+			cred, err := lookupCredential(s.User, s.Group)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), hc.GetTimeout())
+			defer cancel()
+
+			var out bytes.Buffer
+			cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+			cmd.Dir = p.Path
+			cmd.Stdout = &out
+			cmd.Stderr = &out
+			cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("%s failed: %s: %s", command, err, strings.TrimSpace(out.String()))
+			}
+			return nil
+		},
+	}
+}
+
+func lookupCredential(userName string, groupName string) (*syscall.Credential, error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return nil, err
+	}
+	g, err := user.LookupGroup(groupName)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/atelierdisko/hoi/builder"
+	"github.com/atelierdisko/hoi/project"
+	"github.com/atelierdisko/hoi/server"
+	"github.com/atelierdisko/hoi/system"
+	systemd "github.com/coreos/go-systemd/dbus"
+)
+
+func NewLocationRunner(s *server.Config, p *project.Config, conn *systemd.Conn) *LocationRunner {
+	return &LocationRunner{
+		s:     s,
+		p:     p,
+		build: builder.NewBuilder(builder.KindLocation, p, s),
+		sys:   system.NewSystemd(system.SystemdKindLocation, p, s, conn),
+	}
+}
+
+// Runs the services of locations served by a service backend, each
+// location has its own unit, named after the location, i.e.
+// "api.service". Static and PHP locations are served by NGINX and
+// the app's PHP-FPM pool and need no unit.
+type LocationRunner struct {
+	s     *server.Config
+	p     *project.Config
+	sys   *system.Systemd
+	build *builder.Builder
+}
+
+func (r LocationRunner) Disable() error {
+	services, err := r.sys.ListInstalledServices()
+	if err != nil {
+		return err
+	}
+	for _, uS := range services {
+		if err := r.sys.StopAndDisable(uS); err != nil {
+			return err
+		}
+		if err := r.sys.Uninstall(uS); err != nil {
+			return err
+		}
+	}
+	return r.build.Clean()
+}
+
+func (r LocationRunner) Enable() error {
+	if err := r.buildFiles(r.build); err != nil {
+		return err
+	}
+
+	files, err := r.build.ListAvailable()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := r.sys.Install(f); err != nil {
+			return err
+		}
+		if err := r.sys.EnableAndStart(filepath.Base(f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r LocationRunner) Plan(scratch string) ([]Change, error) {
+	b := r.build.Scratch(scratch)
+
+	if err := r.buildFiles(b); err != nil {
+		return nil, err
+	}
+	built, err := b.ListAvailable()
+	if err != nil {
+		return nil, err
+	}
+	installed, err := r.sys.ListInstalledFiles()
+	if err != nil {
+		return nil, err
+	}
+	return planFiles(built, installed, r.sys.InstallPath), nil
+}
+
+func (r LocationRunner) buildFiles(b *builder.Builder) error {
+	tS, err := b.LoadTemplate("default.service")
+	if err != nil {
+		return err
+	}
+	for _, v := range r.p.Location {
+		if v.Kind != project.AppKindService {
+			continue
+		}
+		tmplData := struct {
+			P        *project.Config
+			S        *server.Config
+			L        project.LocationDirective
+			EnvFiles []string
+		}{
+			P:        r.p,
+			S:        r.s,
+			L:        v,
+			EnvFiles: envFiles(r.s, r.p, builder.KindLocation, v.GetID()),
+		}
+		err = b.WriteTemplate(
+			fmt.Sprintf("%s.service", v.GetID()),
+			tS,
+			tmplData,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r LocationRunner) Commit() error {
+	return r.sys.ReloadIfDirty()
+}
+
+func (r LocationRunner) Status() ([]UnitReport, error) {
+	reports := make([]UnitReport, 0, len(r.p.Location))
+
+	for _, l := range r.p.GetLocations() {
+		if l.Kind != project.AppKindService {
+			continue
+		}
+		reports = append(reports, reportUnit(r.sys, system.SystemdKindLocation, l.Path, l.GetID()+".service"))
+	}
+	return reports, nil
+}
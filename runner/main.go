@@ -8,7 +8,11 @@
 // one or multiple systems, into which artifacts are installed.
 package runner
 
-import "archive/tar"
+import (
+	"archive/tar"
+
+	"github.com/atelierdisko/hoi/system"
+)
 
 // Runnable describes methods common to each runner. Runnable methods are called
 // "steps" as these methods are invoked one after another in a fixed order. Steps
@@ -29,7 +33,108 @@ type Runnable interface {
 	Commit() error
 }
 
+// Deployers are able to replace what they run without interruption:
+// the new generation is started next to the current one, which is
+// retired only after all runners have been committed:
+//
+//   Deploy -> Commit -> ... -> Retire
+//
+// When a project is (re)loaded, Deploy is used instead of Disable and
+// Enable. A Deployer that fails to deploy must leave the current
+// generation untouched, so it is not disabled when rolling back.
+type Deployer interface {
+	Deploy() error
+	Retire() error
+}
+
+// Checkers are able to tell whether what they run actually works.
+// Checks are run periodically, Wait is used as a step after
+// committing, so a project is only considered loaded once all of its
+// checks pass.
+type Checker interface {
+	Checks() []Check
+	// Blocks until all checks passed once, or one of them didn't
+	// pass within its grace period.
+	Wait() error
+}
+
 // Dumpers are able to create dumps of objects under their control.
 type Dumper interface {
 	Dump(*tar.Writer) error
 }
+
+// Restorers are able to restore objects under their control from
+// dumps created by a Dumper. Restorers must skip any entries of
+// the archive they don't know how to handle.
+type Restorer interface {
+	Restore(*tar.Reader) error
+}
+
+// Planners are able to tell which changes enabling them would cause,
+// without actually making these changes. Build results go into the
+// given scratch directory instead of the configured build path.
+type Planner interface {
+	Plan(scratch string) ([]Change, error)
+}
+
+// Reporters are able to tell the live status of the units they
+// installed.
+type Reporter interface {
+	Status() ([]UnitReport, error)
+}
+
+// A UnitReport describes the live status of a single unit.
+type UnitReport struct {
+	// The hoi-internal kind of unit, i.e. system.SystemdKindWorker.
+	Kind string
+	// Name of the directive the unit belongs to, i.e. the name of a
+	// worker; empty for the app service.
+	Directive string
+	Status    system.UnitStatus
+	// Set if the status could not be retrieved, i.e. because the unit
+	// is not loaded.
+	Error string
+}
+
+func reportUnit(sys *system.Systemd, kind string, directive string, unit string) UnitReport {
+	status, err := sys.Status(unit)
+
+	report := UnitReport{Kind: kind, Directive: directive, Status: status}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// A Change describes a single change enabling a runner would cause.
+// Changes to files are expressed through Target and Source, all
+// other changes (i.e. SQL statements) through a Note.
+type Change struct {
+	// Absolute path to the currently installed file; the file may
+	// not exist, yet.
+	Target string
+	// Absolute path to the file that would be installed as Target,
+	// empty if Target would be removed.
+	Source string
+	// A human readable description of the change.
+	Note string
+}
+
+// Maps built files to the targets they would be installed as, and
+// adds removals for installed files that would not be replaced.
+func planFiles(built []string, installed []string, target func(string) string) []Change {
+	changes := make([]Change, 0, len(built))
+	seen := make(map[string]bool)
+
+	for _, f := range built {
+		t := target(f)
+		changes = append(changes, Change{Target: t, Source: f})
+		seen[t] = true
+	}
+	for _, t := range installed {
+		if !seen[t] {
+			changes = append(changes, Change{Target: t})
+		}
+	}
+	return changes
+}
@@ -22,14 +22,15 @@ func NewPHPRunner(s *server.Config, p *project.Config, conn *systemd.Conn) *PHPR
 	}
 }
 
-// The PHP runner allows to configure PHP on a per project basis.
+// The PHP runner gives each PHP project its own PHP-FPM pool, with its
+// own socket, processes and PHP settings. A project exhausting its
+// processes doesn't affect other projects.
 //
-// This is achieved by putting a PHP configuration file into a place where PHP
-// generally looks for autoload-able configuration files while using the PATH[0]
-// feature. Other approaches have proven to be buggy[1].
+// Pools replace PHP configuration files using the PATH[0] feature,
+// which were put into a place where PHP generally looks for
+// autoload-able configuration files. These are removed, when found.
 //
 // [0] http://php.net/manual/pl/ini.sections.php
-// [1] https://bugs.php.net/bug.php?id=63965
 type PHPRunner struct {
 	s     *server.Config
 	p     *project.Config
@@ -38,6 +39,11 @@ type PHPRunner struct {
 }
 
 func (r PHPRunner) Disable() error {
+	if r.p.App.Kind == project.AppKindPHP {
+		if err := r.sys.UninstallLegacy(); err != nil {
+			return err
+		}
+	}
 	if ok, err := r.sys.IsInstalled(); !ok || err != nil {
 		return nil // nothing to disable
 	}
@@ -51,18 +57,7 @@ func (r PHPRunner) Enable() error {
 	if r.p.App.Kind != project.AppKindPHP {
 		return nil // nothing to do
 	}
-	tS, err := r.build.LoadTemplate("php.ini")
-	if err != nil {
-		return err
-	}
-	tmplData := struct {
-		P *project.Config
-		S *server.Config
-	}{
-		P: r.p,
-		S: r.s,
-	}
-	if err := r.build.WriteTemplate("php.ini", tS, tmplData); err != nil {
+	if err := r.buildFiles(r.build); err != nil {
 		return err
 	}
 
@@ -78,6 +73,49 @@ func (r PHPRunner) Enable() error {
 	return nil
 }
 
+// As the install location depends on the PHP version in use, changes
+// can only be planned for PHP projects.
+func (r PHPRunner) Plan(scratch string) ([]Change, error) {
+	if r.p.App.Kind != project.AppKindPHP {
+		return nil, nil // nothing to do
+	}
+	b := r.build.Scratch(scratch)
+
+	if err := r.buildFiles(b); err != nil {
+		return nil, err
+	}
+	built, err := b.ListAvailable()
+	if err != nil {
+		return nil, err
+	}
+	target, err := r.sys.InstallPath()
+	if err != nil {
+		return nil, err
+	}
+	return planFiles(built, nil, func(string) string { return target }), nil
+}
+
+func (r PHPRunner) buildFiles(b *builder.Builder) error {
+	tS, err := b.LoadTemplate("pool.conf")
+	if err != nil {
+		return err
+	}
+	socket, err := r.p.App.GetPoolSocket(r.p, r.s)
+	if err != nil {
+		return err
+	}
+	tmplData := struct {
+		P      *project.Config
+		S      *server.Config
+		Socket string
+	}{
+		P:      r.p,
+		S:      r.s,
+		Socket: socket,
+	}
+	return b.WriteTemplate("pool.conf", tS, tmplData)
+}
+
 func (r PHPRunner) Commit() error {
 	return r.sys.ReloadIfDirty()
 }
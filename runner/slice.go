@@ -0,0 +1,113 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runner
+
+import (
+	"github.com/atelierdisko/hoi/builder"
+	"github.com/atelierdisko/hoi/project"
+	"github.com/atelierdisko/hoi/server"
+	"github.com/atelierdisko/hoi/system"
+	"github.com/coreos/go-systemd/dbus"
+)
+
+// The unprefixed name of the project's slice, see system.Systemd.
+const sliceUnit = ".slice"
+
+func NewSliceRunner(s *server.Config, p *project.Config, conn *dbus.Conn) *SliceRunner {
+	return &SliceRunner{
+		s:     s,
+		p:     p,
+		build: builder.NewBuilder(builder.KindSlice, p, s),
+		sys:   system.NewSystemd(system.SystemdKindSlice, p, s, conn),
+	}
+}
+
+// Places app services, workers and crons of a project inside a
+// common systemd slice. This allows to limit resources of the project
+// as a whole - so a single project can't starve all others - and to
+// account for its resource usage.
+//
+// Must run before the runners installing units inside the slice.
+type SliceRunner struct {
+	s     *server.Config
+	p     *project.Config
+	sys   *system.Systemd
+	build *builder.Builder
+}
+
+// Removes the slice. The slice is not stopped, as this would stop
+// all units inside it; units are stopped by their runners and the
+// slice goes away with them.
+func (r SliceRunner) Disable() error {
+	files, err := r.sys.ListInstalledFiles()
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		if err := r.sys.Uninstall(sliceUnit); err != nil {
+			return err
+		}
+	}
+	return r.build.Clean()
+}
+
+func (r SliceRunner) Enable() error {
+	if err := r.buildFiles(r.build); err != nil {
+		return err
+	}
+	files, err := r.build.ListAvailable()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := r.sys.Install(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r SliceRunner) Commit() error {
+	return r.sys.ReloadIfDirty()
+}
+
+func (r SliceRunner) Plan(scratch string) ([]Change, error) {
+	b := r.build.Scratch(scratch)
+
+	if err := r.buildFiles(b); err != nil {
+		return nil, err
+	}
+	built, err := b.ListAvailable()
+	if err != nil {
+		return nil, err
+	}
+	installed, err := r.sys.ListInstalledFiles()
+	if err != nil {
+		return nil, err
+	}
+	return planFiles(built, installed, r.sys.InstallPath), nil
+}
+
+func (r SliceRunner) buildFiles(b *builder.Builder) error {
+	tS, err := b.LoadTemplate("default.slice")
+	if err != nil {
+		return err
+	}
+	tmplData := struct {
+		P *project.Config
+		S *server.Config
+	}{
+		P: r.p,
+		S: r.s,
+	}
+	return b.WriteTemplate(sliceUnit, tS, tmplData)
+}
+
+// Reports the status of the slice, which includes the aggregate
+// resource usage of the project's units.
+func (r SliceRunner) Status() ([]UnitReport, error) {
+	return []UnitReport{reportUnit(r.sys, system.SystemdKindSlice, "", sliceUnit)}, nil
+}
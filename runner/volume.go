@@ -8,8 +8,11 @@ package runner
 import (
 	"archive/tar"
 	"fmt"
+	"io"
 	"log"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/atelierdisko/hoi/builder"
 	"github.com/atelierdisko/hoi/project"
@@ -56,16 +59,57 @@ func (r VolumeRunner) Enable() error {
 	if len(r.p.Volume) == 0 {
 		return nil // nothing to do
 	}
-	t, err := r.build.LoadTemplate("default.mount")
-	if err != nil {
+	for _, v := range r.p.Volume {
+		if err := r.fs.SetupVolume(v); err != nil {
+			return err
+		}
+	}
+	if err := r.buildFiles(r.build); err != nil {
 		return err
 	}
 
-	for _, v := range r.p.Volume {
-		if err := r.fs.SetupVolume(v); err != nil {
+	files, err := r.build.ListAvailable()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := r.sys.Install(f); err != nil {
+			return err
+		}
+		if err := r.sys.EnableAndStart(filepath.Base(f)); err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+func (r VolumeRunner) Plan(scratch string) ([]Change, error) {
+	b := r.build.Scratch(scratch)
+
+	if err := r.buildFiles(b); err != nil {
+		return nil, err
+	}
+	built, err := b.ListAvailable()
+	if err != nil {
+		return nil, err
+	}
+	installed, err := r.sys.ListInstalledFiles()
+	if err != nil {
+		return nil, err
+	}
+	return planFiles(built, installed, r.sys.InstallPath), nil
+}
+
+func (r VolumeRunner) buildFiles(b *builder.Builder) error {
+	if len(r.p.Volume) == 0 {
+		return nil // nothing to do
+	}
+	t, err := b.LoadTemplate("default.mount")
+	if err != nil {
+		return err
+	}
 
+	for _, v := range r.p.Volume {
 		tmplData := struct {
 			P *project.Config
 			S *server.Config
@@ -75,7 +119,7 @@ func (r VolumeRunner) Enable() error {
 			S: r.s,
 			V: v,
 		}
-		err = r.build.WriteTemplate(
+		err = b.WriteTemplate(
 			fmt.Sprintf("%s.mount", r.sys.EscapeUnitName(v.Path)),
 			t,
 			tmplData,
@@ -84,19 +128,6 @@ func (r VolumeRunner) Enable() error {
 			return err
 		}
 	}
-
-	files, err := r.build.ListAvailable()
-	if err != nil {
-		return err
-	}
-	for _, f := range files {
-		if err := r.sys.Install(f); err != nil {
-			return err
-		}
-		if err := r.sys.EnableAndStart(filepath.Base(f)); err != nil {
-			return err
-		}
-	}
 	return nil
 }
 
@@ -104,6 +135,16 @@ func (r VolumeRunner) Commit() error {
 	return r.sys.ReloadIfDirty()
 }
 
+func (r VolumeRunner) Status() ([]UnitReport, error) {
+	reports := make([]UnitReport, 0, len(r.p.Volume))
+
+	for _, v := range r.p.Volume {
+		unit := fmt.Sprintf("%s.mount", r.sys.EscapeUnitName(v.Path))
+		reports = append(reports, reportUnit(r.sys, system.SystemdKindVolume, v.Path, unit))
+	}
+	return reports, nil
+}
+
 // Creates dumps of all persistent volumes.
 func (r VolumeRunner) Dump(tw *tar.Writer) error {
 	for _, v := range r.p.Volume {
@@ -118,3 +159,88 @@ func (r VolumeRunner) Dump(tw *tar.Writer) error {
 	}
 	return nil
 }
+
+// Restores persistent volumes from a dump. Entries for volumes the
+// project doesn't define or for temporary volumes are skipped.
+func (r VolumeRunner) Restore(tr *tar.Reader) error {
+	restored := make(map[string]project.VolumeDirective)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(header.Name, "volume/") {
+			continue
+		}
+		v, rel, ok := r.lookupVolume(header.Name)
+		if !ok {
+			log.Printf("skipping %s, no persistent volume to restore into", header.Name)
+			continue
+		}
+		if _, ok := restored[v.Path]; !ok {
+			log.Printf("restoring volume %s", v.Path)
+
+			if err := r.fs.SetupVolume(v); err != nil {
+				return err
+			}
+			restored[v.Path] = v
+		}
+		if err := r.fs.RestoreVolumeEntry(v, rel, header, tr); err != nil {
+			return err
+		}
+	}
+	for _, v := range restored {
+		if err := r.fs.ChownVolume(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Maps an archive entry name back to the volume it was dumped from
+// and returns the entry's path relative to the volume.
+//
+// Dumps of earlier versions nest entries under the volume's source
+// path, instead of its path inside the project; these are still
+// understood:
+//
+//  volume/var/lib/hoi/project_5c3b/app/media/e0/foo.jpg -> volume/app/media/e0/foo.jpg
+func (r VolumeRunner) lookupVolume(name string) (project.VolumeDirective, string, bool) {
+	name = path.Clean(name)
+
+	names := []string{name}
+	if legacy, ok := legacyVolumeEntryName(name); ok {
+		names = append(names, legacy)
+	}
+	for _, n := range names {
+		for _, v := range r.p.Volume {
+			if v.IsTemporary {
+				continue
+			}
+			base := path.Join("volume", v.Path)
+
+			if n == base || strings.HasPrefix(n, base+"/") {
+				return v, strings.TrimPrefix(n, base), true
+			}
+		}
+	}
+	return project.VolumeDirective{}, "", false
+}
+
+// Maps an entry name of the earlier dump layout to the current one,
+// by dropping the run path up to and including the project's
+// namespace directory, see VolumeDirective.GetRunPath().
+func legacyVolumeEntryName(name string) (string, bool) {
+	parts := strings.Split(name, "/")
+
+	for i, part := range parts {
+		if i > 0 && strings.HasPrefix(part, "project_") {
+			return path.Join(append([]string{"volume"}, parts[i+1:]...)...), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runner
+
+import (
+	"testing"
+
+	"github.com/atelierdisko/hoi/project"
+)
+
+func TestLookupVolumeForDumpEntry(t *testing.T) {
+	hoifile := `
+volume "app/media" {}
+volume "app/media_versions" {}
+volume "tmp" {
+	isTemporary = true
+}
+`
+	cfg, err := project.NewFromString(hoifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := VolumeRunner{p: cfg}
+
+	expected := map[string][2]string{
+		"volume/app/media/":                    {"app/media", ""},
+		"volume/app/media/e0/foo.jpg":          {"app/media", "/e0/foo.jpg"},
+		"volume/app/media_versions/e0/foo.jpg": {"app/media_versions", "/e0/foo.jpg"},
+		// Layout of earlier versions.
+		"volume/var/lib/hoi/project_5c3b/app/media/e0/foo.jpg": {"app/media", "/e0/foo.jpg"},
+		"volume/var/lib/hoi/project_5c3b/app/media/":           {"app/media", ""},
+	}
+	for name, e := range expected {
+		v, rel, ok := r.lookupVolume(name)
+		if !ok {
+			t.Errorf("failed to lookup volume for %s", name)
+			continue
+		}
+		if v.Path != e[0] || rel != e[1] {
+			t.Errorf("result: %s, %s | expected: %s, %s", v.Path, rel, e[0], e[1])
+		}
+	}
+
+	unexpected := []string{
+		"volume/tmp/foo",
+		"volume/app/med/foo.jpg",
+		"volume/other/foo.jpg",
+		"volume/var/lib/hoi/project_5c3b/tmp/foo",
+	}
+	for _, name := range unexpected {
+		if v, _, ok := r.lookupVolume(name); ok {
+			t.Errorf("unexpectedly found volume %s for %s", v.Path, name)
+		}
+	}
+}
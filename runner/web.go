@@ -12,9 +12,14 @@ import (
 	"crypto/md5"
 	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/atelierdisko/hoi/builder"
 	"github.com/atelierdisko/hoi/project"
+	"github.com/atelierdisko/hoi/secret"
 	"github.com/atelierdisko/hoi/server"
 	"github.com/atelierdisko/hoi/system"
 	"github.com/coreos/go-systemd/dbus"
@@ -22,22 +27,24 @@ import (
 
 func NewWebRunner(s *server.Config, p *project.Config, conn *dbus.Conn) *WebRunner {
 	return &WebRunner{
-		s:     s,
-		p:     p,
-		build: builder.NewScopedBuilder(builder.KindWeb, "servers/*.conf", p, s),
-		nginx: system.NewNGINX(p, s, conn),
-		ssl:   system.NewSSL(p, s),
+		s:       s,
+		p:       p,
+		build:   builder.NewScopedBuilder(builder.KindWeb, "servers/*.conf", p, s),
+		nginx:   system.NewNGINX(p, s, conn),
+		ssl:     system.NewSSL(p, s),
+		secrets: secret.NewResolver(s, p),
 	}
 }
 
 // Will serve project under configured domains using aliases and
 // redirects, enforcing authentication and SSL encryption.
 type WebRunner struct {
-	s     *server.Config
-	p     *project.Config
-	build *builder.Builder
-	nginx *system.NGINX
-	ssl   *system.SSL
+	s       *server.Config
+	p       *project.Config
+	build   *builder.Builder
+	nginx   *system.NGINX
+	ssl     *system.SSL
+	secrets *secret.Resolver
 }
 
 func (r WebRunner) Disable() error {
@@ -74,22 +81,124 @@ func (r WebRunner) Enable() error {
 		}
 	}
 
-	if creds := r.p.GetCreds(); len(creds) != 0 {
-		var tmp []byte
-		buf := bytes.NewBuffer(tmp)
+	tmplData := r.templateData(r.ssl.GetCertificate, r.ssl.GetCertificateKey)
+	if err := r.buildFiles(r.build, tmplData); err != nil {
+		return err
+	}
 
-		// APR1-MD5 is the strongest hash nginx supports for basic auth
-		salt := generateAPR1Salt()
+	files, err := r.build.ListAvailable()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := r.nginx.Install(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		for user, password := range creds {
-			buf.WriteString(fmt.Sprintf("%s:%s\n", user, computeAPR1(password, salt)))
+// Plans changes to server configuration and includes, as well as to
+// SSL certificates and keys. Includes are not installed but used
+// directly from the build path, changes to them are planned against
+// the build path.
+func (r WebRunner) Plan(scratch string) ([]Change, error) {
+	changes := make([]Change, 0)
+	b := r.build.Scratch(scratch)
+
+	if len(r.p.Domain) > 0 {
+		// Certificates might not have been installed, yet. We are
+		// just interested in their paths.
+		lenient := func(get func(string) (string, error)) func(string) (string, error) {
+			return func(fqdn string) (string, error) {
+				path, _ := get(fqdn)
+				return path, nil
+			}
 		}
-		if err := r.build.WriteFile("passwords", buf); err != nil {
-			return err
+		tmplData := r.templateData(
+			lenient(r.ssl.GetCertificate),
+			lenient(r.ssl.GetCertificateKey),
+		)
+		if err := r.buildFiles(b, tmplData); err != nil {
+			return changes, err
+		}
+	}
+
+	built, err := b.ListAvailable()
+	if err != nil {
+		return changes, err
+	}
+	servers, err := r.nginx.ListInstalled()
+	if err != nil {
+		return changes, err
+	}
+	installed := make([]string, 0, len(servers))
+	for _, s := range servers {
+		installed = append(installed, r.nginx.InstallPath(s))
+	}
+	changes = append(changes, planFiles(built, installed, r.nginx.InstallPath)...)
+
+	if _, err := os.Stat(b.Path()); err == nil {
+		err = filepath.Walk(b.Path(), func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel := strings.TrimPrefix(path, b.Path()+"/")
+
+			if f.IsDir() || strings.HasPrefix(rel, "servers/") {
+				return nil
+			}
+			if rel == "passwords" {
+				// Hashes are salted randomly, there is nothing to
+				// compare, and we don't want to disclose them.
+				users := make([]string, 0)
+				for user := range r.p.GetCreds() {
+					users = append(users, user)
+				}
+				sort.Strings(users)
+
+				changes = append(changes, Change{
+					Note: fmt.Sprintf("update basic auth credentials for: %s", strings.Join(users, ", ")),
+				})
+				return nil
+			}
+			changes = append(changes, Change{
+				Target: filepath.Join(r.build.Path(), rel),
+				Source: path,
+			})
+			return nil
+		})
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	certs := r.p.GetCerts()
+	for domain, ssl := range certs {
+		notes, err := r.ssl.Plan(domain, ssl)
+		if err != nil {
+			return changes, err
+		}
+		for _, note := range notes {
+			changes = append(changes, Change{Note: note})
 		}
 	}
+	domains, err := r.ssl.ListInstalled()
+	if err != nil {
+		return changes, err
+	}
+	for _, domain := range domains {
+		if _, ok := certs[domain]; !ok {
+			changes = append(changes, Change{
+				Note: fmt.Sprintf("remove SSL cert and key for %s", domain),
+			})
+		}
+	}
+	return changes, nil
+}
 
-	tmplData := struct {
+func (r WebRunner) templateData(getCert func(string) (string, error), getKey func(string) (string, error)) interface{} {
+	return struct {
 		P                    *project.Config
 		S                    *server.Config
 		GetSSLCertificate    func(fqdn string) (string, error)
@@ -98,40 +207,65 @@ func (r WebRunner) Enable() error {
 	}{
 		P:                    r.p,
 		S:                    r.s,
-		GetSSLCertificate:    r.ssl.GetCertificate,
-		GetSSLCertificateKey: r.ssl.GetCertificateKey,
+		GetSSLCertificate:    getCert,
+		GetSSLCertificateKey: getKey,
 		// even though we symlink parts of the build path, config files
 		// should not rely on symlinking but reference the original
 		// created files
 		WebConfigPath: r.build.Path(),
 	}
-	if err := r.build.LoadWriteTemplates(tmplData); err != nil {
-		return err
-	}
+}
 
-	files, err := r.build.ListAvailable()
-	if err != nil {
-		return err
-	}
-	for _, f := range files {
-		if err := r.nginx.Install(f); err != nil {
+func (r WebRunner) buildFiles(b *builder.Builder, tmplData interface{}) error {
+	if creds := r.p.GetCreds(); len(creds) != 0 {
+		var tmp []byte
+		buf := bytes.NewBuffer(tmp)
+
+		// APR1-MD5 is the strongest hash nginx supports for basic auth
+		salt := generateAPR1Salt()
+
+		for user, ref := range creds {
+			password, err := r.secrets.Resolve(ref)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(fmt.Sprintf("%s:%s\n", user, computeAPR1(password, salt)))
+		}
+		if err := b.WriteFile("passwords", buf); err != nil {
 			return err
 		}
 	}
-	return nil
+	return b.LoadWriteTemplates(tmplData)
 }
 
 func (r WebRunner) Commit() error {
-	if !system.SSLDirty {
+	if !system.SSLDirty.Clear() {
 		return r.nginx.ReloadIfDirty()
 	}
 	if err := r.nginx.Reload(); err != nil {
+		system.SSLDirty.Set()
 		return err
 	}
-	system.SSLDirty = false
 	return nil
 }
 
+// Obtains or renews certificates of domains using ACME, where
+// needed, and reloads the web server to pick up new certificates.
+// Must only be called once the project has been enabled, as
+// challenges are answered via the project's servers. The ACME client
+// is shared with other projects renewed during the same run.
+func (r WebRunner) RenewCertificates(acme *system.ACME) error {
+	for _, d := range r.p.Domain {
+		if !d.SSL.IsACME() {
+			continue
+		}
+		if err := r.ssl.RenewACME(d, acme); err != nil {
+			return err
+		}
+	}
+	return r.Commit()
+}
+
 const apr1ABC string = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
 
 // 8 byte long salt from APR1 alphabet
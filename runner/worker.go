@@ -83,29 +83,9 @@ func (r WorkerRunner) Disable() error {
 }
 
 func (r WorkerRunner) Enable() error {
-	tS, err := r.build.LoadTemplate("default@.service")
-	if err != nil {
+	if err := r.buildFiles(r.build); err != nil {
 		return err
 	}
-	for _, v := range r.p.Worker {
-		tmplData := struct {
-			P *project.Config
-			S *server.Config
-			W project.WorkerDirective
-		}{
-			P: r.p,
-			S: r.s,
-			W: v,
-		}
-		err = r.build.WriteTemplate(
-			fmt.Sprintf("%s@.service", v.GetID()),
-			tS,
-			tmplData,
-		)
-		if err != nil {
-			return err
-		}
-	}
 
 	files, err := r.build.ListAvailable()
 	if err != nil {
@@ -138,6 +118,81 @@ func (r WorkerRunner) Enable() error {
 	return nil
 }
 
+func (r WorkerRunner) Plan(scratch string) ([]Change, error) {
+	b := r.build.Scratch(scratch)
+
+	if err := r.buildFiles(b); err != nil {
+		return nil, err
+	}
+	built, err := b.ListAvailable()
+	if err != nil {
+		return nil, err
+	}
+	installed, err := r.sys.ListInstalledFiles()
+	if err != nil {
+		return nil, err
+	}
+	return planFiles(built, installed, r.sys.InstallPath), nil
+}
+
+func (r WorkerRunner) buildFiles(b *builder.Builder) error {
+	tS, err := b.LoadTemplate("default@.service")
+	if err != nil {
+		return err
+	}
+	for _, v := range r.p.Worker {
+		tmplData := struct {
+			P        *project.Config
+			S        *server.Config
+			W        project.WorkerDirective
+			EnvFiles []string
+		}{
+			P:        r.p,
+			S:        r.s,
+			W:        v,
+			EnvFiles: envFiles(r.s, r.p, builder.KindWorker, v.GetID()),
+		}
+		err = b.WriteTemplate(
+			fmt.Sprintf("%s@.service", v.GetID()),
+			tS,
+			tmplData,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r WorkerRunner) Commit() error {
 	return r.sys.ReloadIfDirty()
 }
+
+// Reports the status of each worker instance.
+func (r WorkerRunner) Status() ([]UnitReport, error) {
+	reports := make([]UnitReport, 0, len(r.p.Worker))
+
+	for _, w := range r.p.Worker {
+		for i := uint(1); i <= w.GetInstances(); i++ {
+			unit := fmt.Sprintf("%s@%d.service", w.GetID(), i)
+			reports = append(reports, reportUnit(r.sys, system.SystemdKindWorker, w.GetID(), unit))
+		}
+	}
+	return reports, nil
+}
+
+// Returns the health checks of workers that have one.
+func (r WorkerRunner) Checks() []Check {
+	checks := make([]Check, 0)
+
+	for _, w := range r.p.Worker {
+		if w.HealthCheck.IsEnabled() {
+			checks = append(checks, commandCheck("worker "+w.GetID(), w.HealthCheck, r.p, r.s))
+		}
+	}
+	return checks
+}
+
+func (r WorkerRunner) Wait() error {
+	return waitChecks(r.Checks())
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Retrieves secrets from files inside a directory, each file holds
+// a single secret and is named after it; names may contain slashes
+// to use subdirectories (i.e. "db/example"). The directory and the
+// files must be owned by root and not be accessible by anyone else.
+type File struct {
+	Path string
+}
+
+func (p File) Get(name string) (string, error) {
+	clean := filepath.Clean(name)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid secret name %s", name)
+	}
+	if err := checkRootOnly(p.Path); err != nil {
+		return "", err
+	}
+	file := filepath.Join(p.Path, clean)
+
+	if err := checkRootOnly(file); err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file %s: %s", file, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// Ensures the file is owned by root and has no permissions for group
+// and others.
+func checkRootOnly(file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fmt.Errorf("failed to access %s: %s", file, err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s must not be accessible by group or others, has mode %s", file, info.Mode().Perm())
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != 0 {
+		return fmt.Errorf("%s must be owned by root", file)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Resolves secret references used in project configuration. Secret
+// values are retrieved from providers, which are pluggable: the
+// provider for "!secret:" references is selected in server
+// configuration.
+package secret
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/atelierdisko/hoi/project"
+	"github.com/atelierdisko/hoi/server"
+)
+
+// Available providers for "!secret:" references.
+const (
+	ProviderFile    = "file"
+	ProviderSystemd = "systemd"
+)
+
+// Providers retrieve secret values by name.
+type Provider interface {
+	Get(name string) (string, error)
+}
+
+func NewResolver(s *server.Config, p *project.Config) *Resolver {
+	return &Resolver{s: s, p: p}
+}
+
+// Resolves secret references of a project into their values. As
+// projects must not be able to read each other's secrets, "!secret:"
+// references are scoped to the project: "!secret:db/example" of the
+// project with ID 5c3b8e2f resolves to the secret
+// "5c3b8e2f/db/example". The ID is used, as it is derived from the
+// project's path and - unlike the name and context - can't be chosen
+// by the Hoifile. "!env:" references may only use variables allowed in
+// server configuration.
+type Resolver struct {
+	s *server.Config
+	p *project.Config
+}
+
+// Resolves the value, if it is a secret reference. Any other value
+// is returned unchanged. Errors never contain the secret value.
+func (r Resolver) Resolve(v string) (string, error) {
+	prefix, name, ok := project.ParseSecretRef(v)
+	if !ok {
+		return v, nil
+	}
+	if name == "" {
+		return "", fmt.Errorf("failed to resolve secret %s: empty name", v)
+	}
+
+	var p Provider
+	switch prefix {
+	case project.EnvRefPrefix:
+		if !r.isAllowedEnv(name) {
+			return "", fmt.Errorf("failed to resolve secret %s: variable not allowed by server configuration", v)
+		}
+		p = &Env{}
+	case project.SecretRefPrefix:
+		scoped, err := r.scope(name)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve secret %s: %s", v, err)
+		}
+		name = scoped
+
+		provider, err := r.provider()
+		if err != nil {
+			return "", err
+		}
+		p = provider
+	}
+	value, err := p.Get(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secret %s: %s", v, err)
+	}
+	return value, nil
+}
+
+func (r Resolver) isAllowedEnv(name string) bool {
+	for _, v := range r.s.Secret.AllowEnv {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Prefixes the name with the project's ID, names must not leave that
+// scope.
+func (r Resolver) scope(name string) (string, error) {
+	if path.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("invalid name")
+	}
+	if r.p == nil || r.p.ID == "" {
+		return "", fmt.Errorf("project has no ID")
+	}
+	return path.Join(r.p.ID, name), nil
+}
+
+func (r Resolver) provider() (Provider, error) {
+	switch r.s.Secret.Provider {
+	case "", ProviderFile:
+		if r.s.Secret.Path == "" {
+			return nil, fmt.Errorf("no secret path configured for file secret provider")
+		}
+		return &File{Path: r.s.Secret.Path}, nil
+	case ProviderSystemd:
+		return &SystemdCredentials{}, nil
+	}
+	return nil, fmt.Errorf("unknown secret provider: %s", r.s.Secret.Provider)
+}
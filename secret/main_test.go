@@ -0,0 +1,150 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/atelierdisko/hoi/project"
+	"github.com/atelierdisko/hoi/server"
+)
+
+func TestResolvePlainValueUnchanged(t *testing.T) {
+	s, _ := server.New()
+	r := NewResolver(s, &project.Config{ID: "5c3b8e2f", Path: "/var/www/example"})
+
+	v, err := r.Resolve("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "s3cret" {
+		t.Errorf("expected plain value to be returned unchanged, got: %s", v)
+	}
+}
+
+func TestResolveEnv(t *testing.T) {
+	os.Setenv("HOI_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("HOI_TEST_SECRET")
+
+	os.Setenv("HOI_TEST_OTHER", "s3cret")
+	defer os.Unsetenv("HOI_TEST_OTHER")
+
+	s, _ := server.New()
+	s.Secret.AllowEnv = []string{"HOI_TEST_SECRET", "HOI_TEST_MISSING"}
+	r := NewResolver(s, &project.Config{ID: "5c3b8e2f", Path: "/var/www/example"})
+
+	v, err := r.Resolve("!env:HOI_TEST_SECRET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "s3cret" {
+		t.Errorf("expected s3cret, got: %s", v)
+	}
+	if _, err := r.Resolve("!env:HOI_TEST_MISSING"); err == nil {
+		t.Error("expected error for unset variable")
+	}
+	if _, err := r.Resolve("!env:HOI_TEST_OTHER"); err == nil {
+		t.Error("expected error for variable not allowed by server configuration")
+	}
+}
+
+func TestResolveFile(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("secret files must be owned by root")
+	}
+	tmp, err := ioutil.TempDir("", "hoi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	os.Chmod(tmp, 0700)
+	os.MkdirAll(filepath.Join(tmp, "5c3b8e2f", "db"), 0700)
+	ioutil.WriteFile(filepath.Join(tmp, "5c3b8e2f", "db", "example"), []byte("s3cret\n"), 0600)
+	os.MkdirAll(filepath.Join(tmp, "other", "db"), 0700)
+	ioutil.WriteFile(filepath.Join(tmp, "other", "db", "example"), []byte("0th3r\n"), 0600)
+
+	s, _ := server.New()
+	s.Secret.Path = tmp
+	r := NewResolver(s, &project.Config{ID: "5c3b8e2f", Name: "other", Path: "/var/www/example"})
+
+	v, err := r.Resolve("!secret:db/example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "s3cret" {
+		t.Errorf("expected s3cret, got: %s", v)
+	}
+	if _, err := r.Resolve("!secret:../etc/passwd"); err == nil {
+		t.Error("expected error for secret outside directory")
+	}
+	if _, err := r.Resolve("!secret:../other/db/example"); err == nil {
+		t.Error("expected error for secret of another project")
+	}
+
+	os.Chmod(filepath.Join(tmp, "5c3b8e2f", "db", "example"), 0644)
+	if _, err := r.Resolve("!secret:db/example"); err == nil {
+		t.Error("expected error for world-readable secret file")
+	}
+}
+
+func TestRedactConfigKeepsOriginal(t *testing.T) {
+	cfg, err := project.NewFromString(`
+domain example.org {
+	auth = {
+		user = "preview"
+		password = "s3cret"
+	}
+}
+database example {
+	password = "!secret:db/example"
+}
+worker media {
+	command = "bin/process-media"
+	env {
+		API_KEY = "s3cret"
+	}
+}
+location "/api" {
+	kind = "service"
+	command = "bin/api"
+	env {
+		API_TOKEN = "s3cret"
+	}
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted := RedactConfig(cfg)
+
+	if redacted.Domain["example.org"].Auth.Password != Mask {
+		t.Errorf("expected auth password to be masked, got: %s", redacted.Domain["example.org"].Auth.Password)
+	}
+	if redacted.Database["example"].Password != "!secret:db/example" {
+		t.Errorf("expected reference to be kept, got: %s", redacted.Database["example"].Password)
+	}
+	if redacted.Worker["media"].Env["API_KEY"] != Mask {
+		t.Errorf("expected environment variable to be masked, got: %s", redacted.Worker["media"].Env["API_KEY"])
+	}
+	if redacted.Location["/api"].Env["API_TOKEN"] != Mask {
+		t.Errorf("expected environment variable of location to be masked, got: %s", redacted.Location["/api"].Env["API_TOKEN"])
+	}
+	if cfg.Domain["example.org"].Auth.Password != "s3cret" || cfg.Worker["media"].Env["API_KEY"] != "s3cret" || cfg.Location["/api"].Env["API_TOKEN"] != "s3cret" {
+		t.Error("redacting modified original configuration")
+	}
+}
+
+func TestRedactString(t *testing.T) {
+	r := RedactString("near 'IDENTIFIED BY 's3cret'' at line 1", "s3cret", "")
+
+	if r != "near 'IDENTIFIED BY '********'' at line 1" {
+		t.Errorf("unexpected result: %s", r)
+	}
+}
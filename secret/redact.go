@@ -0,0 +1,95 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/atelierdisko/hoi/project"
+)
+
+// Replaces secret values wherever they would be shown.
+const Mask = "********"
+
+// Returns a copy of the configuration with plaintext passwords and
+// environment variables masked. Secret references are kept, as they
+// don't disclose the secret itself. The given configuration is not
+// modified.
+func RedactConfig(cfg *project.Config) *project.Config {
+	redacted := *cfg
+
+	redacted.Env = redactEnv(cfg.Env)
+	redacted.App.Env = redactEnv(cfg.App.Env)
+
+	redacted.Domain = make(map[string]project.DomainDirective, len(cfg.Domain))
+	for k, v := range cfg.Domain {
+		v.Auth.Password = redactValue(v.Auth.Password)
+		redacted.Domain[k] = v
+	}
+	redacted.Database = make(map[string]project.DatabaseDirective, len(cfg.Database))
+	for k, v := range cfg.Database {
+		v.Password = redactValue(v.Password)
+		redacted.Database[k] = v
+	}
+	redacted.Cron = make(map[string]project.CronDirective, len(cfg.Cron))
+	for k, v := range cfg.Cron {
+		v.Env = redactEnv(v.Env)
+		redacted.Cron[k] = v
+	}
+	redacted.Worker = make(map[string]project.WorkerDirective, len(cfg.Worker))
+	for k, v := range cfg.Worker {
+		v.Env = redactEnv(v.Env)
+		redacted.Worker[k] = v
+	}
+	redacted.Location = make(map[string]project.LocationDirective, len(cfg.Location))
+	for k, v := range cfg.Location {
+		v.Env = redactEnv(v.Env)
+		redacted.Location[k] = v
+	}
+	return &redacted
+}
+
+// Environment variables may hold anything, so all plaintext values
+// are masked.
+func redactEnv(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(env))
+	for k, v := range env {
+		redacted[k] = redactValue(v)
+	}
+	return redacted
+}
+
+func redactValue(v string) string {
+	if v == "" || project.IsSecretRef(v) {
+		return v
+	}
+	return Mask
+}
+
+// Masks any occurrences of the given secrets inside s, i.e. in
+// messages of errors returned by a database server, which might
+// echo back the statement. Empty secrets are ignored.
+func RedactString(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		s = strings.Replace(s, secret, Mask, -1)
+	}
+	return s
+}
+
+// Like RedactString() but for errors. Returns nil for nil errors.
+func RedactError(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	return errors.New(RedactString(err.Error(), secrets...))
+}
@@ -0,0 +1,36 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Retrieves secrets from systemd credentials passed to hoid, i.e.
+// via LoadCredential= in its unit. As credential names cannot
+// contain slashes, these are replaced by underscores: "db/example"
+// is read from the credential "db_example".
+type SystemdCredentials struct{}
+
+func (p SystemdCredentials) Get(name string) (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", fmt.Errorf("no systemd credentials available, CREDENTIALS_DIRECTORY is not set")
+	}
+	cred := strings.Replace(name, "/", "_", -1)
+	if cred == "." || cred == ".." {
+		return "", fmt.Errorf("invalid secret name %s", name)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, cred))
+	if err != nil {
+		return "", fmt.Errorf("failed to read systemd credential %s: %s", cred, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
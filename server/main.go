@@ -10,7 +10,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"path/filepath"
+	"strings"
 
 	"github.com/hashicorp/hcl"
 )
@@ -52,17 +54,66 @@ type Config struct {
 	BuildPath    string
 	DataPath     string
 
+	// Maximum number of projects to process at the same time, when
+	// operating on all projects. Defaults to 4.
+	Concurrency int
+
 	Web        WebDirective
 	NGINX      NGINXDirective
 	SSL        SSLDirective
 	AppService AppServiceDirective
 	PHP        PHPDirective
+	Node       RuntimeDirective
+	Python     RuntimeDirective
 	Cron       CronDirective
 	Worker     WorkerDirective
 	Systemd    SystemdDirective
 	Database   DatabaseDirective
 	MySQL      MySQLDirective
+	PostgreSQL PostgreSQLDirective
 	Volume     VolumeDirective
+	Secret     SecretDirective
+	Limits     LimitsDirective
+}
+
+func (cfg Config) GetConcurrency() int {
+	if cfg.Concurrency < 1 {
+		return 4
+	}
+	return cfg.Concurrency
+}
+
+// Configures how secret references in project configuration
+// (i.e. "!secret:db/example") are resolved.
+type SecretDirective struct {
+	// Either "file" (default) or "systemd".
+	Provider string
+	// Directory to read secrets from, when using the file provider.
+	Path string
+	// Environment variables of hoid, which projects may reference via
+	// "!env:"; all others are off limits.
+	AllowEnv []string
+}
+
+// Used when neither the project nor the server configuration give a
+// memory limit.
+const DefaultMemoryLimit = "200M"
+
+// Resource limits for units running project code, see
+// project.Limits for the format of values.
+type LimitsDirective struct {
+	// Applied where projects don't give a limit.
+	Default ResourceLimits
+	// Projects may not give limits above these; empty values don't
+	// limit.
+	Max ResourceLimits
+}
+
+type ResourceLimits struct {
+	Memory   string
+	CPUQuota string
+	TasksMax int
+	IOWeight int
 }
 
 type VolumeDirective struct {
@@ -82,15 +133,172 @@ type NGINXDirective struct {
 
 type AppServiceDirective struct {
 	Enabled bool
+	// Directory holding the sockets of services listening on unix
+	// sockets, each project gets a subdirectory; defaults to
+	// /run/hoi.
+	RunPath string
+}
+
+func (drv AppServiceDirective) GetRunPath() string {
+	if drv.RunPath == "" {
+		return "/run/hoi"
+	}
+	return drv.RunPath
 }
 
 type PHPDirective struct {
 	Enabled bool
 	// Service may be a templated string.
 	Service string
-	// RunPath may be a templated string.
+	// Directory per-project PHP settings were installed into, before
+	// each project got its own pool; left over settings are removed
+	// from it. RunPath may be a templated string.
 	RunPath string
+	// Directory per-project PHP-FPM pool configurations are installed
+	// into. PoolPath may be a templated string.
+	PoolPath string
+	// Path of the socket of a project's pool. PoolSocket may be a
+	// templated string.
+	PoolSocket string
+	Version    string
+	// PHP settings projects may give, i.e. "memory_limit"; a trailing
+	// "*" matches any setting with the prefix, i.e. "session.*". If
+	// empty, all settings not denied are allowed.
+	AllowSettings []string
+	// PHP settings projects must not give, even if allowed, i.e.
+	// "disable_functions".
+	DenySettings []string
+	// Paths projects may make accessible via the open_basedir
+	// setting, in addition to paths inside the project root.
+	AllowOpenBasedir []string
+	// PHP versions installed on the server, keyed by version, i.e.
+	// "7.2". When given, apps may only use these versions.
+	Runtime map[string]PHPRuntimeDirective
+}
+
+// A PHP version installed on the server, with its own PHP-FPM
+// service. Empty fields are taken from the PHP directive, all fields
+// may be templated strings:
+//
+//   runtime "7.2" {
+//     service = "php7.2-fpm"
+//     poolPath = "/etc/php/7.2/fpm/pool.d"
+//   }
+type PHPRuntimeDirective struct {
+	// The version the runtime provides, taken from the key.
+	Version    string
+	Service    string
+	PoolPath   string
+	PoolSocket string
+	RunPath    string
+}
+
+// Returns the runtime for the given PHP version, falling back to the
+// default version if none is given. The most specific matching
+// runtime wins: "7.2.5" is served by "7.2" before "7". When no
+// runtimes have been declared, any version is accepted.
+func (drv PHPDirective) GetRuntime(version string) (PHPRuntimeDirective, error) {
+	if version == "" {
+		version = drv.Version
+	}
+	defaults := PHPRuntimeDirective{
+		Version:    version,
+		Service:    drv.Service,
+		PoolPath:   drv.PoolPath,
+		PoolSocket: drv.PoolSocket,
+		RunPath:    drv.RunPath,
+	}
+	if len(drv.Runtime) == 0 {
+		return defaults, nil
+	}
+	if version == "" {
+		return defaults, fmt.Errorf("no PHP version given and no default version configured")
+	}
+
+	var match string
+	for v := range drv.Runtime {
+		if v != version && !strings.HasPrefix(version, v+".") {
+			continue
+		}
+		if len(v) > len(match) {
+			match = v
+		}
+	}
+	if match == "" {
+		return defaults, fmt.Errorf("no runtime configured for PHP version %s", version)
+	}
+	r := drv.Runtime[match]
+
+	if r.Service == "" {
+		r.Service = defaults.Service
+	}
+	if r.PoolPath == "" {
+		r.PoolPath = defaults.PoolPath
+	}
+	if r.PoolSocket == "" {
+		r.PoolSocket = defaults.PoolSocket
+	}
+	if r.RunPath == "" {
+		r.RunPath = defaults.RunPath
+	}
+	return r, nil
+}
+
+// Checks whether projects may give the PHP setting.
+func (drv PHPDirective) IsSettingPermitted(name string) bool {
+	matches := func(patterns []string) bool {
+		for _, p := range patterns {
+			if p == name || (strings.HasSuffix(p, "*") && strings.HasPrefix(name, strings.TrimSuffix(p, "*"))) {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(drv.DenySettings) {
+		return false
+	}
+	return len(drv.AllowSettings) == 0 || matches(drv.AllowSettings)
+}
+
+// Configures the interpreters available to apps of a language, i.e.
+// Node.js or Python.
+type RuntimeDirective struct {
+	// The default language version to use, when the app doesn't
+	// give one, i.e. "18" or "3.11".
 	Version string
+	// Paths to interpreters keyed by language version:
+	//
+	//   interpreters = {
+	//     "16" = "/opt/node-16/bin/node"
+	//     "18" = "/opt/node-18/bin/node"
+	//   }
+	Interpreters map[string]string
+}
+
+// Returns the path to the interpreter for the given language version,
+// falling back to the default version if none is given. The most
+// specific matching version wins: "3.11.2" is served by "3.11"
+// before "3".
+func (drv RuntimeDirective) GetInterpreter(version string) (string, error) {
+	if version == "" {
+		version = drv.Version
+	}
+	if version == "" {
+		return "", fmt.Errorf("no language version given and no default version configured")
+	}
+	var match string
+	for v := range drv.Interpreters {
+		if v != version && !strings.HasPrefix(version, v+".") {
+			continue
+		}
+		if len(v) > len(match) {
+			match = v
+		}
+	}
+	if match == "" {
+		return "", fmt.Errorf("no interpreter configured for version %s", version)
+	}
+	return drv.Interpreters[match], nil
 }
 
 type CronDirective struct {
@@ -118,6 +326,57 @@ type MySQLDirective struct {
 	UseLegacy   bool
 }
 
+type PostgreSQLDirective struct {
+	// Host and optional port; or the absolute path to the directory
+	// containing the unix socket.
+	Host     string
+	User     string
+	Password string
+	// One of the SSL modes supported by the client library; defaults
+	// to "disable".
+	SSLMode string
+}
+
+// Whether a PostgreSQL server has been configured.
+func (drv PostgreSQLDirective) IsEnabled() bool {
+	return drv.Host != ""
+}
+
+// Returns host and port to connect to; for unix sockets the host is
+// the socket directory.
+func (drv PostgreSQLDirective) GetHostPort() (string, string) {
+	if strings.HasPrefix(drv.Host, "/") {
+		return drv.Host, "5432"
+	}
+	host, port, err := net.SplitHostPort(drv.Host)
+	if err != nil {
+		return drv.Host, "5432"
+	}
+	return host, port
+}
+
+func (drv PostgreSQLDirective) GetSSLMode() string {
+	if drv.SSLMode == "" {
+		return "disable"
+	}
+	return drv.SSLMode
+}
+
+// Returns the data source name, to connect to the given database.
+func (drv PostgreSQLDirective) DSN(database string) string {
+	host, port := drv.GetHostPort()
+
+	quote := func(v string) string {
+		v = strings.Replace(v, `\`, `\\`, -1)
+		v = strings.Replace(v, `'`, `\'`, -1)
+		return "'" + v + "'"
+	}
+	return fmt.Sprintf(
+		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		quote(host), port, quote(drv.User), quote(drv.Password), quote(database), drv.GetSSLMode(),
+	)
+}
+
 func decodeInto(cfg *Config, s string) (*Config, error) {
 	if err := hcl.Decode(cfg, s); err != nil {
 		return cfg, err
@@ -129,7 +388,22 @@ func decodeInto(cfg *Config, s string) (*Config, error) {
 
 	cfg.NGINX.RunPath, _ = filepath.Abs(cfg.NGINX.RunPath)
 	cfg.Systemd.RunPath, _ = filepath.Abs(cfg.Systemd.RunPath)
-	cfg.PHP.RunPath, _ = filepath.Abs(cfg.PHP.RunPath)
+	if cfg.PHP.RunPath != "" {
+		cfg.PHP.RunPath, _ = filepath.Abs(cfg.PHP.RunPath)
+	}
+
+	if cfg.Secret.Path != "" {
+		cfg.Secret.Path, _ = filepath.Abs(cfg.Secret.Path)
+	}
+	if cfg.AppService.RunPath != "" {
+		cfg.AppService.RunPath, _ = filepath.Abs(cfg.AppService.RunPath)
+	}
+	if cfg.SSL.ACME.StatePath != "" {
+		cfg.SSL.ACME.StatePath, _ = filepath.Abs(cfg.SSL.ACME.StatePath)
+	}
+	if cfg.SSL.ACME.ChallengePath != "" {
+		cfg.SSL.ACME.ChallengePath, _ = filepath.Abs(cfg.SSL.ACME.ChallengePath)
+	}
 
 	// key is Pattern
 	for k, _ := range cfg.SSL.System {
@@ -138,5 +412,15 @@ func decodeInto(cfg *Config, s string) (*Config, error) {
 		cfg.SSL.System[k] = e
 	}
 
+	// key is Version
+	for k, _ := range cfg.PHP.Runtime {
+		r := cfg.PHP.Runtime[k]
+		r.Version = k
+		cfg.PHP.Runtime[k] = r
+	}
+
+	if err := cfg.SSL.ACME.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid ACME configuration: %s", err)
+	}
 	return cfg, nil
 }
@@ -0,0 +1,118 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"testing"
+)
+
+func TestDecodeConcurrency(t *testing.T) {
+	cfg, err := NewFromString(`concurrency = 8`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GetConcurrency() != 8 {
+		t.Errorf("expected concurrency of 8, got: %d", cfg.GetConcurrency())
+	}
+
+	cfg, err = NewFromString(`concurrency = 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GetConcurrency() != 4 {
+		t.Errorf("expected default concurrency of 4, got: %d", cfg.GetConcurrency())
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	cfg, err := NewFromString(`
+limits {
+	default {
+		tasksMax = 512
+		ioWeight = 100
+	}
+	max {
+		tasksMax = 1024
+	}
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Limits.Default.TasksMax != 512 || cfg.Limits.Default.IOWeight != 100 {
+		t.Errorf("failed to decode default limits, got: %+v", cfg.Limits.Default)
+	}
+	if cfg.Limits.Max.TasksMax != 1024 {
+		t.Errorf("failed to decode maximum limits, got: %+v", cfg.Limits.Max)
+	}
+}
+
+func TestDecodeRejectsIncompleteACME(t *testing.T) {
+	_, err := NewFromString(`
+SSL {
+	ACME {
+		enabled = true
+		statePath = "/var/lib/hoi/acme"
+	}
+}
+`)
+	if err == nil {
+		t.Error("failed to detect missing directory URL")
+	}
+
+	_, err = NewFromString(`
+SSL {
+	ACME {
+		enabled = true
+		directoryURL = "https://acme-v02.api.letsencrypt.org/directory"
+	}
+}
+`)
+	if err == nil {
+		t.Error("failed to detect missing state path")
+	}
+
+	_, err = NewFromString(`
+SSL {
+	ACME {
+		enabled = true
+		directoryURL = "https://acme-v02.api.letsencrypt.org/directory"
+		statePath = "/var/lib/hoi/acme"
+	}
+}
+`)
+	if err == nil {
+		t.Error("failed to detect missing challenge path")
+	}
+
+	_, err = NewFromString(`
+SSL {
+	ACME {
+		enabled = true
+		directoryURL = "https://acme-v02.api.letsencrypt.org/directory"
+		statePath = "/var/lib/hoi/acme"
+		challengePath = "/var/lib/hoi/acme/challenges"
+	}
+}
+`)
+	if err == nil {
+		t.Error("failed to detect challenge path inside state path")
+	}
+
+	_, err = NewFromString(`
+SSL {
+	ACME {
+		enabled = true
+		directoryURL = "https://acme-v02.api.letsencrypt.org/directory"
+		statePath = "/var/lib/hoi/acme"
+		challengePath = "/var/www/acme-challenges"
+	}
+}
+`)
+	if err != nil {
+		t.Errorf("failed to accept complete ACME configuration: %s", err)
+	}
+}
@@ -8,6 +8,8 @@ package server
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 type SSLDirective struct {
@@ -15,6 +17,78 @@ type SSLDirective struct {
 	RunPath string
 	// A list of system certificates and keys.
 	System map[string]SystemSSLDirective
+	// Settings for obtaining certificates automatically.
+	ACME ACMEDirective
+	// Duration string (i.e. "504h"), certificates expiring within
+	// this window are flagged; optional, defaults to 21 days.
+	ExpiryWarning string
+}
+
+func (drv SSLDirective) GetExpiryWarning() (time.Duration, error) {
+	if drv.ExpiryWarning == "" {
+		return 21 * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(drv.ExpiryWarning)
+}
+
+// Certificates for projects using the "!acme" certificate are
+// obtained via the ACME protocol, answering HTTP-01 challenges.
+type ACMEDirective struct {
+	Enabled bool
+	// URL of the ACME server's directory resource.
+	DirectoryURL string
+	// Contact email address to register the account with; optional.
+	Email string
+	// Absolute path to a PEM encoded CA certificate, the ACME server's
+	// certificate is verified against in addition to the system's CAs;
+	// optional, useful for testing against a local ACME server.
+	TrustedCA string
+	// Directory where the account key, obtained certificates and keys
+	// are kept; private to hoid.
+	StatePath string
+	// Directory challenge responses are written to and served from by
+	// NGINX; must be readable by the web server and not be placed
+	// inside the state path.
+	ChallengePath string
+	// Duration string (i.e. "720h"), certificates are renewed this
+	// long before they expire; optional, defaults to 30 days.
+	RenewBefore string
+	// Duration string, how often to check for certificates that need
+	// to be obtained or renewed; optional, defaults to 12 hours.
+	CheckInterval string
+}
+
+func (drv ACMEDirective) GetRenewBefore() (time.Duration, error) {
+	if drv.RenewBefore == "" {
+		return 30 * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(drv.RenewBefore)
+}
+
+func (drv ACMEDirective) GetCheckInterval() (time.Duration, error) {
+	if drv.CheckInterval == "" {
+		return 12 * time.Hour, nil
+	}
+	return time.ParseDuration(drv.CheckInterval)
+}
+
+func (drv ACMEDirective) validate() error {
+	if !drv.Enabled {
+		return nil
+	}
+	if drv.DirectoryURL == "" {
+		return fmt.Errorf("no directory URL given")
+	}
+	if drv.StatePath == "" {
+		return fmt.Errorf("no state path given")
+	}
+	if drv.ChallengePath == "" {
+		return fmt.Errorf("no challenge path given")
+	}
+	if rel, err := filepath.Rel(drv.StatePath, drv.ChallengePath); err == nil && !strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("challenge path must not be inside state path %s", drv.StatePath)
+	}
+	return nil
 }
 
 type SystemSSLDirective struct {
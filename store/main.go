@@ -17,6 +17,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/atelierdisko/hoi/project"
 )
@@ -38,8 +39,10 @@ type Entity struct {
 type Store struct {
 	// Mutex protecting access to data.
 	sync.RWMutex
-	file string
-	data map[string]Entity
+	// Mutex serializing writes to the file.
+	persist sync.Mutex
+	file    string
+	data    map[string]Entity
 }
 
 // Loads database file contents into memory.
@@ -77,7 +80,7 @@ func (s *Store) Load() error {
 
 // Persists in-memory data to store db file. Automatically called when
 // in-memory data has been modified.
-func (s Store) Persist() error {
+func (s *Store) Persist() error {
 	// Swap contents at the very end, when we are sure that
 	// everything else worked.
 	var buf []byte
@@ -87,13 +90,18 @@ func (s Store) Persist() error {
 	for id, entity := range s.data {
 		c, err := json.Marshal(entity)
 		if err != nil {
+			s.RUnlock()
 			return err
 		}
 		b.WriteString(fmt.Sprintf("%s#%s\n", id, string(c)))
 	}
 	s.RUnlock()
 
-	// Atomic, we don't need locks, last writer wins.
+	// Concurrent writers would interleave their contents, last writer
+	// wins.
+	s.persist.Lock()
+	defer s.persist.Unlock()
+
 	f, err := os.OpenFile(s.file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
@@ -110,7 +118,7 @@ func (s *Store) Close() error {
 	return nil
 }
 
-func (s Store) Has(id string) bool {
+func (s *Store) Has(id string) bool {
 	s.RLock()
 	defer s.RUnlock()
 
@@ -118,7 +126,7 @@ func (s Store) Has(id string) bool {
 	return hasKey
 }
 
-func (s Store) Read(id string) (Entity, error) {
+func (s *Store) Read(id string) (Entity, error) {
 	s.RLock()
 	defer s.RUnlock()
 
@@ -129,7 +137,7 @@ func (s Store) Read(id string) (Entity, error) {
 	return entity, nil
 }
 
-func (s Store) ReadAll() []Entity {
+func (s *Store) ReadAll() []Entity {
 	s.RLock()
 	defer s.RUnlock()
 
@@ -157,6 +165,7 @@ func (s *Store) Delete(id string) error {
 	s.Lock()
 
 	if _, hasKey := s.data[id]; !hasKey {
+		s.Unlock()
 		return fmt.Errorf("failed to delete from store: no id %s", id)
 	}
 	delete(s.data, id)
@@ -165,7 +174,7 @@ func (s *Store) Delete(id string) error {
 	return s.Persist()
 }
 
-func (s Store) ReadStatus(id string) (project.MetaStatus, error) {
+func (s *Store) ReadStatus(id string) (project.MetaStatus, error) {
 	s.RLock()
 	defer s.RUnlock()
 
@@ -179,10 +188,95 @@ func (s *Store) WriteStatus(id string, status project.MetaStatus) error {
 	s.Lock()
 
 	if _, hasKey := s.data[id]; !hasKey {
+		s.Unlock()
 		return fmt.Errorf("failed to write status %s: no id %s", status, id)
 	}
 	entity := s.data[id]
-	entity.Meta.Status = status
+
+	// Entities read earlier share the meta, see WriteHealth().
+	meta := *entity.Meta
+	meta.Status = status
+	entity.Meta = &meta
+	s.data[id] = entity
+	s.Unlock()
+
+	return s.Persist()
+}
+
+// Records the result of a health check; a nil error means the check
+// passed. An active project with a failing check becomes degraded,
+// and active again once all of its checks pass. Other statuses are
+// kept, as operations on the project take precedence.
+func (s *Store) WriteHealth(id string, check string, checkErr error) error {
+	s.Lock()
+
+	if _, hasKey := s.data[id]; !hasKey {
+		s.Unlock()
+		return fmt.Errorf("failed to write health: no id %s", id)
+	}
+	entity := s.data[id]
+
+	// Entities read earlier share the meta, so it is replaced
+	// instead of modified.
+	meta := *entity.Meta
+	status := meta.Status
+	meta.Health = make(map[string]project.HealthResult, len(entity.Meta.Health)+1)
+	for k, v := range entity.Meta.Health {
+		meta.Health[k] = v
+	}
+	result := project.HealthResult{Checked: time.Now()}
+	if checkErr != nil {
+		result.Error = checkErr.Error()
+	}
+	prev, seen := meta.Health[check]
+	meta.Health[check] = result
+
+	failing := false
+	for _, r := range meta.Health {
+		if r.Error != "" {
+			failing = true
+		}
+	}
+	if failing && meta.Status == project.StatusActive {
+		meta.Status = project.StatusDegraded
+	}
+	if !failing && meta.Status == project.StatusDegraded {
+		meta.Status = project.StatusActive
+	}
+	entity.Meta = &meta
+	s.data[id] = entity
+	s.Unlock()
+
+	// Checks run often, persist only what is worth keeping.
+	if seen && prev.Error == result.Error && meta.Status == status {
+		return nil
+	}
+	return s.Persist()
+}
+
+// Records the errors of a failed operation and of the rollback that
+// possibly followed it. Passing nil errors clears them.
+func (s *Store) WriteError(id string, err error, rollbackErr error) error {
+	s.Lock()
+
+	if _, hasKey := s.data[id]; !hasKey {
+		s.Unlock()
+		return fmt.Errorf("failed to write error: no id %s", id)
+	}
+	entity := s.data[id]
+
+	// Entities read earlier share the meta, see WriteHealth().
+	meta := *entity.Meta
+	meta.Error = ""
+	meta.RollbackError = ""
+
+	if err != nil {
+		meta.Error = err.Error()
+	}
+	if rollbackErr != nil {
+		meta.RollbackError = rollbackErr.Error()
+	}
+	entity.Meta = &meta
 	s.data[id] = entity
 	s.Unlock()
 
@@ -6,7 +6,12 @@
 package store
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/atelierdisko/hoi/project"
@@ -57,3 +62,117 @@ func TestStoreCount(t *testing.T) {
 	}
 	store.Close()
 }
+
+func TestWriteError(t *testing.T) {
+	file := "/tmp/store-test.db"
+	store := New(file)
+	cfg, _ := project.NewFromString("name = \"test\"")
+
+	if err := store.Write("fookey", cfg); err != nil {
+		t.Error(err)
+	}
+	if err := store.WriteError("fookey", errors.New("failed"), nil); err != nil {
+		t.Error(err)
+	}
+	e, _ := store.Read("fookey")
+	if e.Meta.Error != "failed" || e.Meta.RollbackError != "" {
+		t.Errorf("unexpected errors recorded: %#v", e.Meta)
+	}
+	if err := store.WriteError("fookey", nil, nil); err != nil {
+		t.Error(err)
+	}
+	if err := store.WriteStatus("fookey", project.StatusFailed); err != nil {
+		t.Error(err)
+	}
+	if e.Meta.Error != "failed" || e.Meta.Status == project.StatusFailed {
+		t.Error("writing modified meta of entity read earlier")
+	}
+	e, _ = store.Read("fookey")
+	if e.Meta.Error != "" {
+		t.Error("failed to clear error")
+	}
+	if err := store.WriteError("barkey", nil, nil); err == nil {
+		t.Error("expected error for missing id")
+	}
+	store.Close()
+	os.Remove(file)
+}
+
+func TestWriteHealthDegrades(t *testing.T) {
+	file := "/tmp/store-test.db"
+	store := New(file)
+	cfg, _ := project.NewFromString("name = \"test\"")
+
+	if err := store.Write("fookey", cfg); err != nil {
+		t.Error(err)
+	}
+	store.WriteStatus("fookey", project.StatusActive)
+
+	if err := store.WriteHealth("fookey", "app", errors.New("timeout")); err != nil {
+		t.Error(err)
+	}
+	e, _ := store.Read("fookey")
+	if e.Meta.Status != project.StatusDegraded || e.Meta.Health["app"].Error != "timeout" {
+		t.Errorf("expected project to be degraded, got: %#v", e.Meta)
+	}
+
+	if err := store.WriteHealth("fookey", "app", nil); err != nil {
+		t.Error(err)
+	}
+	e, _ = store.Read("fookey")
+	if e.Meta.Status != project.StatusActive {
+		t.Errorf("expected project to be active again, got: %s", e.Meta.Status)
+	}
+
+	store.WriteStatus("fookey", project.StatusReloading)
+	store.WriteHealth("fookey", "app", errors.New("timeout"))
+	e, _ = store.Read("fookey")
+	if e.Meta.Status != project.StatusReloading {
+		t.Errorf("expected status to be kept, got: %s", e.Meta.Status)
+	}
+	store.Close()
+	os.Remove(file)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "hoi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	file := filepath.Join(tmp, "store.db")
+	store := New(file)
+	cfg, _ := project.NewFromString("name = \"test\"")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("key%d", i)
+
+			for j := 0; j < 20; j++ {
+				if err := store.Write(id, cfg); err != nil {
+					t.Error(err)
+				}
+				store.WriteStatus(id, project.StatusActive)
+				store.Read(id)
+				store.ReadAll()
+				store.Has(id)
+				if err := store.Persist(); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	loaded := New(file)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("failed to load persisted store: %s", err)
+	}
+	if len(loaded.ReadAll()) != 8 {
+		t.Errorf("expected 8 entities, got: %d", len(loaded.ReadAll()))
+	}
+}
@@ -0,0 +1,448 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package system
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/atelierdisko/hoi/server"
+)
+
+// How long to wait for the ACME server to validate challenges and
+// to issue certificates.
+const acmeTimeout = 2 * time.Minute
+
+// How long to wait between fetching a pending resource.
+var acmePollInterval = 2 * time.Second
+
+func NewACME(s *server.Config) (*ACME, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	if s.SSL.ACME.TrustedCA != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		ca, err := ioutil.ReadFile(s.SSL.ACME.TrustedCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trusted ACME CA %s: %s", s.SSL.ACME.TrustedCA, err)
+		}
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("failed to parse trusted ACME CA %s", s.SSL.ACME.TrustedCA)
+		}
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+	}
+	sys := &ACME{s: s, client: client}
+
+	key, err := sys.loadAccountKey()
+	if err != nil {
+		return nil, err
+	}
+	sys.key = key
+	return sys, nil
+}
+
+// A minimal client for the ACME protocol (RFC 8555), that obtains
+// certificates by answering HTTP-01 challenges. Challenge responses
+// are written into a directory which is served by NGINX.
+//
+// A client is meant to be reused for all certificates obtained during
+// a renewal run, so the directory is fetched and the account is
+// registered only once. It is not safe for concurrent use.
+type ACME struct {
+	s      *server.Config
+	client *http.Client
+	// The account key.
+	key *ecdsa.PrivateKey
+	// The account URL, used as key ID once registered.
+	kid   string
+	nonce string
+	dir   struct {
+		NewNonce   string
+		NewAccount string
+		NewOrder   string
+	}
+}
+
+type acmeProblem struct {
+	Type   string
+	Detail string
+}
+
+type acmeOrder struct {
+	Status         string
+	Authorizations []string
+	Finalize       string
+	Certificate    string
+}
+
+type acmeAuthorization struct {
+	Status     string
+	Identifier struct {
+		Value string
+	}
+	Challenges []acmeChallenge
+}
+
+type acmeChallenge struct {
+	Type   string
+	URL    string
+	Token  string
+	Status string
+}
+
+// Obtains a certificate covering the given names, the first name
+// becomes the certificate's common name. Returns the PEM encoded
+// certificate chain.
+func (sys *ACME) Obtain(names []string, key crypto.Signer) ([]byte, error) {
+	if err := sys.discover(); err != nil {
+		return nil, err
+	}
+	if err := sys.register(); err != nil {
+		return nil, err
+	}
+
+	identifiers := make([]map[string]string, 0, len(names))
+	for _, name := range names {
+		identifiers = append(identifiers, map[string]string{"type": "dns", "value": name})
+	}
+	var order acmeOrder
+	header, err := sys.post(sys.dir.NewOrder, map[string]interface{}{"identifiers": identifiers}, &order)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ACME order for %s: %s", strings.Join(names, ", "), err)
+	}
+	orderURL := header.Get("Location")
+
+	for _, url := range order.Authorizations {
+		if err := sys.authorize(url); err != nil {
+			return nil, err
+		}
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: names[0]},
+		DNSNames: names,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %s", err)
+	}
+	payload := map[string]string{"csr": base64.RawURLEncoding.EncodeToString(csr)}
+
+	if _, err := sys.post(order.Finalize, payload, &order); err != nil {
+		return nil, fmt.Errorf("failed to finalize ACME order: %s", err)
+	}
+	err = sys.poll(orderURL, &order, func() (bool, error) {
+		switch order.Status {
+		case "valid":
+			return true, nil
+		case "invalid":
+			return false, fmt.Errorf("ACME order became invalid")
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := sys.postRaw(order.Certificate, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download certificate: %s", err)
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+// Answers the HTTP-01 challenge of an authorization and waits for it
+// to become valid.
+func (sys *ACME) authorize(url string) error {
+	var authz acmeAuthorization
+	if _, err := sys.post(url, nil, &authz); err != nil {
+		return fmt.Errorf("failed to fetch ACME authorization: %s", err)
+	}
+	if authz.Status == "valid" {
+		return nil // reused authorization
+	}
+
+	var challenge *acmeChallenge
+	for k, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			challenge = &authz.Challenges[k]
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no HTTP-01 challenge offered for %s", authz.Identifier.Value)
+	}
+	log.Printf("answering ACME challenge for %s", authz.Identifier.Value)
+
+	dir := sys.s.SSL.ACME.ChallengePath
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create ACME challenge directory %s: %s", dir, err)
+	}
+	file := filepath.Join(dir, filepath.Base(challenge.Token))
+	if err := ioutil.WriteFile(file, []byte(sys.keyAuthorization(challenge.Token)), 0644); err != nil {
+		return fmt.Errorf("failed to write ACME challenge response %s: %s", file, err)
+	}
+	defer os.Remove(file)
+
+	if _, err := sys.post(challenge.URL, struct{}{}, nil); err != nil {
+		return fmt.Errorf("failed to respond to ACME challenge for %s: %s", authz.Identifier.Value, err)
+	}
+	return sys.poll(url, &authz, func() (bool, error) {
+		switch authz.Status {
+		case "valid":
+			return true, nil
+		case "pending", "processing":
+			return false, nil
+		}
+		return false, fmt.Errorf("ACME authorization for %s became %s", authz.Identifier.Value, authz.Status)
+	})
+}
+
+// Repeatedly fetches the resource until done reports completion or
+// fails.
+func (sys *ACME) poll(url string, v interface{}, done func() (bool, error)) error {
+	deadline := time.Now().Add(acmeTimeout)
+
+	for {
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for ACME resource %s", url)
+		}
+		time.Sleep(acmePollInterval)
+
+		if _, err := sys.post(url, nil, v); err != nil {
+			return err
+		}
+	}
+}
+
+func (sys *ACME) discover() error {
+	if sys.dir.NewOrder != "" {
+		return nil // already discovered
+	}
+	res, err := sys.client.Get(sys.s.SSL.ACME.DirectoryURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch ACME directory: %s", err)
+	}
+	defer res.Body.Close()
+
+	if err := json.NewDecoder(res.Body).Decode(&sys.dir); err != nil {
+		return fmt.Errorf("failed to decode ACME directory: %s", err)
+	}
+	return nil
+}
+
+// Registers the account, or - as the account key is reused - looks
+// up the already registered account.
+func (sys *ACME) register() error {
+	if sys.kid != "" {
+		return nil // already registered
+	}
+	payload := map[string]interface{}{"termsOfServiceAgreed": true}
+	if sys.s.SSL.ACME.Email != "" {
+		payload["contact"] = []string{"mailto:" + sys.s.SSL.ACME.Email}
+	}
+	header, err := sys.post(sys.dir.NewAccount, payload, nil)
+	if err != nil {
+		return fmt.Errorf("failed to register ACME account: %s", err)
+	}
+	sys.kid = header.Get("Location")
+	return nil
+}
+
+// Sends a JWS signed request. A nil payload results in a
+// POST-as-GET request. Decodes the response into v, when given, and
+// always closes the response body.
+func (sys *ACME) post(url string, payload interface{}, v interface{}) (http.Header, error) {
+	res, err := sys.postRaw(url, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if v == nil {
+		io.Copy(ioutil.Discard, res.Body)
+		return res.Header, nil
+	}
+	return res.Header, json.NewDecoder(res.Body).Decode(v)
+}
+
+// Like post() but returns the response as is, the caller must close
+// its body. Retries once, if the nonce was rejected.
+func (sys *ACME) postRaw(url string, payload interface{}) (*http.Response, error) {
+	res, err := sys.doPost(url, payload)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 400 {
+		problem := acmeProblem{}
+		json.NewDecoder(res.Body).Decode(&problem)
+		res.Body.Close()
+
+		if problem.Type != "urn:ietf:params:acme:error:badNonce" {
+			return nil, fmt.Errorf("ACME server responded with %s: %s", res.Status, problem.Detail)
+		}
+		if res, err = sys.doPost(url, payload); err != nil {
+			return nil, err
+		}
+		if res.StatusCode >= 400 {
+			res.Body.Close()
+			return nil, fmt.Errorf("ACME server responded with %s", res.Status)
+		}
+	}
+	return res, nil
+}
+
+func (sys *ACME) doPost(url string, payload interface{}) (*http.Response, error) {
+	if sys.nonce == "" {
+		res, err := sys.client.Head(sys.dir.NewNonce)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch ACME nonce: %s", err)
+		}
+		res.Body.Close()
+		sys.nonce = res.Header.Get("Replay-Nonce")
+	}
+	body, err := sys.sign(url, payload)
+	if err != nil {
+		return nil, err
+	}
+	sys.nonce = ""
+
+	res, err := sys.client.Post(url, "application/jose+json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	sys.nonce = res.Header.Get("Replay-Nonce")
+	return res, nil
+}
+
+// Creates a JWS in flattened JSON serialization using ES256.
+func (sys *ACME) sign(url string, payload interface{}) ([]byte, error) {
+	protected := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": sys.nonce,
+		"url":   url,
+	}
+	if sys.kid != "" {
+		protected["kid"] = sys.kid
+	} else {
+		protected["jwk"] = sys.jwk()
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if payload != nil {
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	enc := base64.RawURLEncoding
+	input := enc.EncodeToString(header) + "." + enc.EncodeToString(body)
+
+	hash := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, sys.key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := append(padBigInt(r, 32), padBigInt(s, 32)...)
+
+	return json.Marshal(map[string]string{
+		"protected": enc.EncodeToString(header),
+		"payload":   enc.EncodeToString(body),
+		"signature": enc.EncodeToString(sig),
+	})
+}
+
+// The JSON Web Key of the account key, with members in
+// lexicographical order as required for thumbprints (RFC 7638).
+func (sys *ACME) jwk() map[string]string {
+	enc := base64.RawURLEncoding
+
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   enc.EncodeToString(padBigInt(sys.key.X, 32)),
+		"y":   enc.EncodeToString(padBigInt(sys.key.Y, 32)),
+	}
+}
+
+// The contents of a challenge response.
+func (sys *ACME) keyAuthorization(token string) string {
+	// encoding/json sorts map keys, so the result is canonical.
+	b, _ := json.Marshal(sys.jwk())
+	thumbprint := sha256.Sum256(b)
+
+	return token + "." + base64.RawURLEncoding.EncodeToString(thumbprint[:])
+}
+
+// Loads the account key, generating it on first use.
+func (sys *ACME) loadAccountKey() (*ecdsa.PrivateKey, error) {
+	file := filepath.Join(sys.s.SSL.ACME.StatePath, "account.key")
+
+	if b, err := ioutil.ReadFile(file); err == nil {
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("failed to decode ACME account key %s", file)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(sys.s.SSL.ACME.StatePath, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create ACME state directory: %s", err)
+	}
+	b := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	if err := ioutil.WriteFile(file, b, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write ACME account key %s: %s", file, err)
+	}
+	log.Printf("generated new ACME account key: %s", file)
+	return key, nil
+}
+
+// Left pads the big-endian bytes of i with zeros to size.
+func padBigInt(i *big.Int, size int) []byte {
+	b := i.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
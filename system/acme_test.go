@@ -0,0 +1,321 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package system
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atelierdisko/hoi/server"
+)
+
+// A fake ACME server, implementing just enough of RFC 8555 to issue a
+// certificate for a single order with a single authorization.
+type fakeACME struct {
+	sync.Mutex
+	t             *testing.T
+	url           string
+	challengePath string
+
+	nonces      map[string]bool
+	nonceCount  int
+	rejectNonce bool
+	rejected    int
+
+	// The account's public key and its JWK, as sent on registration.
+	key *ecdsa.PublicKey
+	jwk map[string]string
+
+	answered bool
+	order    map[string]interface{}
+	cert     []byte
+}
+
+func (f *fakeACME) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.nonceCount++
+	nonce := fmt.Sprintf("nonce-%d", f.nonceCount)
+	f.nonces[nonce] = true
+	w.Header().Set("Replay-Nonce", nonce)
+
+	switch r.URL.Path {
+	case "/directory":
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   f.url + "/new-nonce",
+			"newAccount": f.url + "/new-account",
+			"newOrder":   f.url + "/new-order",
+		})
+		return
+	case "/new-nonce":
+		return
+	}
+
+	payload, err := f.verify(r)
+	if err != nil {
+		f.t.Errorf("%s: %s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if f.rejectNonce {
+		f.rejectNonce = false
+		f.rejected++
+
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"type":   "urn:ietf:params:acme:error:badNonce",
+			"detail": "nonce rejected",
+		})
+		return
+	}
+
+	switch r.URL.Path {
+	case "/new-account":
+		w.Header().Set("Location", f.url+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"valid"}`))
+	case "/new-order":
+		f.order = map[string]interface{}{
+			"status":         "pending",
+			"authorizations": []string{f.url + "/authz/1"},
+			"finalize":       f.url + "/order/1/finalize",
+		}
+		w.Header().Set("Location", f.url+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(f.order)
+	case "/authz/1":
+		status := "pending"
+		if f.answered {
+			status = "valid"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":     status,
+			"identifier": map[string]string{"type": "dns", "value": "example.org"},
+			"challenges": []map[string]string{
+				{"type": "dns-01", "url": f.url + "/chall/2", "token": "dns"},
+				{"type": "http-01", "url": f.url + "/chall/1", "token": "t0k3n"},
+			},
+		})
+	case "/chall/1":
+		b, err := ioutil.ReadFile(filepath.Join(f.challengePath, "t0k3n"))
+		if err != nil {
+			f.t.Errorf("failed to read challenge response: %s", err)
+		}
+		jwk, _ := json.Marshal(f.jwk)
+		thumbprint := sha256.Sum256(jwk)
+		expected := "t0k3n." + base64.RawURLEncoding.EncodeToString(thumbprint[:])
+
+		if string(b) != expected {
+			f.t.Errorf("expected challenge response %s, got: %s", expected, b)
+		}
+		f.answered = true
+		w.Write([]byte(`{"status":"processing"}`))
+	case "/order/1/finalize":
+		var p struct{ CSR string }
+		json.Unmarshal(payload, &p)
+
+		der, _ := base64.RawURLEncoding.DecodeString(p.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			f.t.Errorf("failed to parse CSR: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.cert = f.issue(csr)
+
+		f.order["status"] = "processing"
+		json.NewEncoder(w).Encode(f.order)
+	case "/order/1":
+		if f.cert != nil {
+			f.order["status"] = "valid"
+			f.order["certificate"] = f.url + "/cert/1"
+		}
+		json.NewEncoder(w).Encode(f.order)
+	case "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(f.cert)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Checks nonce, URL and signature of a JWS request and returns its
+// payload.
+func (f *fakeACME) verify(r *http.Request) ([]byte, error) {
+	var jws struct {
+		Protected string
+		Payload   string
+		Signature string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, err
+	}
+	enc := base64.RawURLEncoding
+
+	b, _ := enc.DecodeString(jws.Protected)
+	var protected struct {
+		Alg   string
+		Nonce string
+		URL   string
+		Kid   string
+		JWK   map[string]string
+	}
+	if err := json.Unmarshal(b, &protected); err != nil {
+		return nil, err
+	}
+	if !f.nonces[protected.Nonce] {
+		return nil, fmt.Errorf("unknown or reused nonce %q", protected.Nonce)
+	}
+	delete(f.nonces, protected.Nonce)
+
+	if protected.URL != f.url+r.URL.Path {
+		return nil, fmt.Errorf("URL %s does not match request", protected.URL)
+	}
+
+	if r.URL.Path == "/new-account" {
+		if protected.JWK == nil {
+			return nil, fmt.Errorf("no JWK given")
+		}
+		x, _ := enc.DecodeString(protected.JWK["x"])
+		y, _ := enc.DecodeString(protected.JWK["y"])
+		f.jwk = protected.JWK
+		f.key = &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+	} else if protected.Kid != f.url+"/account/1" {
+		return nil, fmt.Errorf("unexpected key ID %q", protected.Kid)
+	}
+
+	sig, _ := enc.DecodeString(jws.Signature)
+	if len(sig) != 64 {
+		return nil, fmt.Errorf("invalid signature length %d", len(sig))
+	}
+	hash := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	rr := new(big.Int).SetBytes(sig[:32])
+	ss := new(big.Int).SetBytes(sig[32:])
+
+	if !ecdsa.Verify(f.key, hash[:], rr, ss) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	return enc.DecodeString(jws.Payload)
+}
+
+// Issues a self-signed certificate for the CSR.
+func (f *fakeACME) issue(csr *x509.CertificateRequest) []byte {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Fake ACME CA"},
+	}, csr.PublicKey, caKey)
+	if err != nil {
+		f.t.Errorf("failed to issue certificate: %s", err)
+		return nil
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestACMEObtain(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "hoi-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	interval := acmePollInterval
+	acmePollInterval = 10 * time.Millisecond
+	defer func() { acmePollInterval = interval }()
+
+	f := &fakeACME{
+		t:             t,
+		challengePath: filepath.Join(tmp, "challenges"),
+		nonces:        make(map[string]bool),
+		// Rejects the first signed request, the client must retry with
+		// a fresh nonce.
+		rejectNonce: true,
+	}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	f.url = srv.URL
+
+	s, _ := server.New()
+	s.SSL.ACME.Enabled = true
+	s.SSL.ACME.DirectoryURL = srv.URL + "/directory"
+	s.SSL.ACME.StatePath = filepath.Join(tmp, "acme")
+	s.SSL.ACME.ChallengePath = f.challengePath
+
+	acme, err := NewACME(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := acme.Obtain([]string{"example.org", "www.example.org"}, key)
+	if err != nil {
+		t.Fatalf("failed to obtain certificate: %s", err)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.rejected != 1 {
+		t.Errorf("expected one rejected nonce, got: %d", f.rejected)
+	}
+	if !f.answered {
+		t.Error("challenge was not answered")
+	}
+	if _, err := os.Stat(filepath.Join(f.challengePath, "t0k3n")); !os.IsNotExist(err) {
+		t.Error("challenge response was not removed")
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		t.Fatalf("failed to decode certificate: %s", b)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cert.DNSNames, ",") != "example.org,www.example.org" {
+		t.Errorf("unexpected certificate names: %v", cert.DNSNames)
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+		t.Error("certificate does not match key")
+	}
+
+	if _, err := os.Stat(filepath.Join(s.SSL.ACME.StatePath, "account.key")); err != nil {
+		t.Errorf("account key was not saved: %s", err)
+	}
+}
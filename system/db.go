@@ -0,0 +1,78 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package system
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// DB is implemented by database servers, on which hoi manages
+// databases and users for projects. Privileges are passed as
+// understood by the database server.
+type DB interface {
+	EnsureDatabase(database string) error
+	EnsureUser(user string, password string) error
+	// Ensures at least the given privileges are granted to the user
+	// on database level.
+	EnsureGrant(user string, database string, privs []string) error
+	EnsureNoGrant(user string, database string, privs []string) error
+	ReloadIfDirty() error
+
+	// Return the statements the corresponding Ensure*() methods
+	// would issue, without issuing them. Passwords are masked.
+	PlanEnsureDatabase(database string) ([]string, error)
+	PlanEnsureUser(user string, password string) ([]string, error)
+	// Only privileges not granted yet are planned.
+	PlanEnsureGrant(user string, database string, privs []string) ([]string, error)
+
+	// Dumps the database as SQL into "database/<name>.sql", see
+	// writeDatabaseDump().
+	DumpDatabase(database string, tw *tar.Writer) error
+	// Restores the database from an SQL dump, objects created are
+	// owned by the given user, where the server supports ownership.
+	RestoreDatabase(database string, user string, r io.Reader) error
+}
+
+// Adds a database dump, buffered in a temporary file, to the archive.
+// All database servers use the same layout, so dumps can be restored
+// regardless of which server created them.
+func writeDatabaseDump(database string, tmp *os.File, tw *tar.Writer) error {
+	// Calculate final size and reset for reading from.
+	_, err := tmp.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+	stat, err := tmp.Stat()
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+	log.Printf("database %s dump created, is %d bytes", database, size)
+
+	header := &tar.Header{
+		Name:     fmt.Sprintf("database/%s.sql", database),
+		Size:     size,
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+		Mode:     0660,
+		Uid:      0,
+		Gid:      0,
+		Uname:    "root",
+		Gname:    "root",
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, tmp); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package system
+
+import (
+	"sync"
+)
+
+// DirtyFlag indicates whether a service needs to be reloaded, to
+// pick up changed configuration. Projects may be processed
+// concurrently, so the flag is safe for concurrent use.
+//
+// Reloading should clear the flag before reloading and set it again
+// when reloading failed. This way changes made while reloading are
+// not lost.
+type DirtyFlag struct {
+	sync.Mutex
+	dirty bool
+}
+
+func (f *DirtyFlag) Set() {
+	f.Lock()
+	defer f.Unlock()
+	f.dirty = true
+}
+
+func (f *DirtyFlag) IsSet() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.dirty
+}
+
+// Clears the flag; returns whether it was set.
+func (f *DirtyFlag) Clear() bool {
+	f.Lock()
+	defer f.Unlock()
+
+	was := f.dirty
+	f.dirty = false
+	return was
+}
+
+// DirtySet is like DirtyFlag, but tracks several services of the
+// same kind separately, i.e. multiple PHP-FPM versions.
+type DirtySet struct {
+	sync.Mutex
+	dirty map[string]bool
+}
+
+func (s *DirtySet) Set(name string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.dirty == nil {
+		s.dirty = make(map[string]bool)
+	}
+	s.dirty[name] = true
+}
+
+func (s *DirtySet) IsSet(name string) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.dirty[name]
+}
+
+// Clears the flag for the named service; returns whether it was set.
+func (s *DirtySet) Clear(name string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	was := s.dirty[name]
+	delete(s.dirty, name)
+	return was
+}
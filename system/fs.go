@@ -7,14 +7,17 @@ package system
 
 import (
 	"archive/tar"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/atelierdisko/hoi/project"
 	"github.com/atelierdisko/hoi/server"
+	"github.com/atelierdisko/hoi/util"
 )
 
 func NewFilesystem(p *project.Config, s *server.Config) *Filesystem {
@@ -86,7 +89,7 @@ func (sys Filesystem) SetupVolume(v project.VolumeDirective) error {
 //  app/media/e0/foo.jpg -> volume/app/media/e0/foo.jpg
 func (sys Filesystem) DumpVolume(v project.VolumeDirective, tw *tar.Writer) error {
 	source := v.GetSource(sys.p, sys.s)
-	base := strings.TrimPrefix(source, sys.p.Path)
+	base := strings.TrimPrefix(v.GetTarget(sys.p), sys.p.Path)
 
 	return filepath.Walk(source, func(path string, f os.FileInfo, err error) error {
 		if err != nil {
@@ -123,3 +126,122 @@ func (sys Filesystem) DumpVolume(v project.VolumeDirective, tw *tar.Writer) erro
 		return err
 	})
 }
+
+// Restores a single entry of a volume dump into the volume's source
+// directory. The given path is relative to the volume, as generated by
+// DumpVolume(). Entries must not escape the volume's source directory,
+// neither by their path, nor by symlinks: neither those inside the
+// dump, nor those already inside the volume.
+func (sys Filesystem) RestoreVolumeEntry(v project.VolumeDirective, path string, header *tar.Header, r io.Reader) error {
+	source := v.GetSource(sys.p, sys.s)
+	target := filepath.Join(source, path)
+
+	if !isInsideDir(source, target) {
+		return fmt.Errorf("refusing to restore %s outside of volume %s", header.Name, source)
+	}
+	// Paths are checked once more after resolving symlinks, before
+	// directories are created and files are written.
+	ensureInside := func(p string) error {
+		ok, err := resolvesInsideDir(source, p)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("refusing to restore %s through symlink leaving volume %s", header.Name, source)
+		}
+		return nil
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := ensureInside(target); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(target, header.FileInfo().Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to restore directory %s: %s", target, err)
+		}
+	case tar.TypeSymlink:
+		if filepath.IsAbs(header.Linkname) || !isInsideDir(source, filepath.Join(filepath.Dir(target), header.Linkname)) {
+			return fmt.Errorf("refusing to restore symlink %s pointing outside of volume %s", header.Name, source)
+		}
+		if err := ensureInside(filepath.Dir(target)); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to restore symlink %s: %s", target, err)
+		}
+		if err := util.ForceSymlink(header.Linkname, target); err != nil {
+			return fmt.Errorf("failed to restore symlink %s: %s", target, err)
+		}
+	case tar.TypeReg:
+		if err := ensureInside(filepath.Dir(target)); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to restore file %s: %s", target, err)
+		}
+		// Replace symlinks instead of writing through them.
+		if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(target); err != nil {
+				return fmt.Errorf("failed to restore file %s: %s", target, err)
+			}
+		}
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, header.FileInfo().Mode().Perm())
+		if err != nil {
+			return fmt.Errorf("failed to restore file %s: %s", target, err)
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(f, r); err != nil {
+			return fmt.Errorf("failed to restore file %s: %s", target, err)
+		}
+	}
+	return nil
+}
+
+// Checks lexically whether path is dir or inside it.
+func isInsideDir(dir string, path string) bool {
+	dir = filepath.Clean(dir)
+	path = filepath.Clean(path)
+
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// Checks whether path is dir or inside it, after resolving symlinks.
+// As path may not exist yet, its nearest existing ancestor is
+// resolved.
+func resolvesInsideDir(dir string, path string) (bool, error) {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false, err
+	}
+	existing := filepath.Clean(path)
+
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+	realExisting, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return false, err
+	}
+	return isInsideDir(realDir, realExisting), nil
+}
+
+// Recursively hands ownership of the volume's contents over to the
+// configured user and group. Uses the same poor-man's Chown as
+// SetupVolume().
+func (sys Filesystem) ChownVolume(v project.VolumeDirective) error {
+	src := v.GetSource(sys.p, sys.s)
+
+	if err := exec.Command("chown", "-R", sys.s.User+":"+sys.s.Group, src).Run(); err != nil {
+		return fmt.Errorf("failed to change ownership of volume %s: %s", src, err)
+	}
+	return nil
+}
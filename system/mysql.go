@@ -15,16 +15,16 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"time"
+	"regexp"
+	"strings"
 
 	"github.com/atelierdisko/hoi/project"
+	"github.com/atelierdisko/hoi/secret"
 	"github.com/atelierdisko/hoi/server"
 )
 
 var (
-	// no need for mutex: all actions are atomic, we
-	// do not reload the whole configuration
-	MySQLDirty bool
+	MySQLDirty DirtyFlag
 )
 
 func NewMySQL(p *project.Config, s *server.Config, conn *sql.DB) *MySQL {
@@ -46,7 +46,7 @@ func (sys MySQL) EnsureDatabase(database string) error {
 		return fmt.Errorf("failed creating MySQL database '%s': %s", database, err)
 	}
 	if num, _ := res.RowsAffected(); num > 0 {
-		MySQLDirty = true
+		MySQLDirty.Set()
 	}
 	return nil
 }
@@ -88,7 +88,7 @@ func (sys MySQL) HasPassword(user string, host string, password string) (bool, e
 
 	rows, err := sys.conn.Query(sql, user, sys.s.MySQL.AccountHost, password)
 	if err != nil {
-		return false, fmt.Errorf("failed to verify MySQL user '%s' on host '%s' has password '%s': %s", user, host, password, err)
+		return false, fmt.Errorf("failed to verify password of MySQL user '%s' on host '%s': %s", user, host, secret.RedactError(err, password))
 	}
 	var count int
 	for rows.Next() {
@@ -124,10 +124,10 @@ func (sys MySQL) EnsureUser(user string, password string) error {
 			sql = fmt.Sprintf("CREATE USER '%s'@'%s' IDENTIFIED BY '%s'", user, sys.s.MySQL.AccountHost, password)
 			res, err := sys.conn.Exec(sql)
 			if err != nil {
-				return fmt.Errorf("failed creating MySQL user '%s' with password '%s': %s", user, password, err)
+				return fmt.Errorf("failed creating MySQL user '%s': %s", user, secret.RedactError(err, password))
 			}
 			if num, _ := res.RowsAffected(); num > 0 {
-				MySQLDirty = true
+				MySQLDirty.Set()
 			}
 			// New user created, password set.
 			return nil
@@ -140,7 +140,7 @@ func (sys MySQL) EnsureUser(user string, password string) error {
 		if err != nil {
 			return fmt.Errorf("failed migrating host for MySQL user '%s': %s", user, err)
 		}
-		MySQLDirty = true
+		MySQLDirty.Set()
 	}
 	// We can now be sure to have a user account with the correct host.
 
@@ -155,7 +155,7 @@ func (sys MySQL) EnsureUser(user string, password string) error {
 	// especially with shared user accounts can lead to unintended
 	// side effects. Current hoi versions will not use shared
 	// accounts, but older ones did.
-	log.Printf("changing MySQL password for user '%s' to '%s'", user, password)
+	log.Printf("changing MySQL password for user '%s'", user)
 
 	if sys.s.MySQL.UseLegacy {
 		// PASSWORD() is deprecated and should be used in legacy systems only.
@@ -166,10 +166,10 @@ func (sys MySQL) EnsureUser(user string, password string) error {
 	}
 	res, err := sys.conn.Exec(sql)
 	if err != nil {
-		return fmt.Errorf("failed setting new password '%s' for MySQL user '%s': %s", password, user, err)
+		return fmt.Errorf("failed setting new password for MySQL user '%s': %s", user, secret.RedactError(err, password))
 	}
 	if num, _ := res.RowsAffected(); num > 0 {
-		MySQLDirty = true
+		MySQLDirty.Set()
 	}
 	return nil
 }
@@ -196,7 +196,7 @@ func (sys MySQL) EnsureGrant(user string, database string, privs []string) error
 			return fmt.Errorf("failed granting MySQL user '%s' privilege '%s' on '%s': %s", user, priv, database, err)
 		}
 		if num, _ := res.RowsAffected(); num > 0 {
-			MySQLDirty = true
+			MySQLDirty.Set()
 		}
 	}
 	return nil
@@ -226,21 +226,21 @@ func (sys MySQL) EnsureNoGrant(user string, database string, privs []string) err
 			continue
 		}
 		if num, _ := res.RowsAffected(); num > 0 {
-			MySQLDirty = true
+			MySQLDirty.Set()
 		}
 	}
 	return nil
 }
 
 func (sys *MySQL) ReloadIfDirty() error {
-	if !MySQLDirty {
+	if !MySQLDirty.Clear() {
 		return nil
 	}
 	sql := "FLUSH PRIVILEGES"
 	if _, err := sys.conn.Exec(sql); err != nil {
+		MySQLDirty.Set()
 		return fmt.Errorf("failed to reload MySQL, has been left in dirty state: %s", err)
 	}
-	MySQLDirty = false
 	return nil
 }
 
@@ -264,53 +264,196 @@ func (sys MySQL) DumpDatabase(database string, tw *tar.Writer) error {
 	defer tmp.Close()
 	defer os.Remove(tmp.Name())
 
-	cmdArgs := []string{
-		"--opt",
-		fmt.Sprintf("-u%s", sys.s.MySQL.User),
-	}
-	if sys.s.MySQL.Password != "" {
-		cmdArgs = append(cmdArgs, fmt.Sprintf("-p%s", sys.s.MySQL.Password))
+	defaults, err := sys.writeClientDefaults()
+	if err != nil {
+		return err
 	}
-	cmdArgs = append(cmdArgs, database)
+	defer os.Remove(defaults)
 
-	cmd := exec.Command("mysqldump", cmdArgs...)
+	// --defaults-extra-file must be the first argument.
+	cmd := exec.Command("mysqldump", "--defaults-extra-file="+defaults, "--opt", database)
 	cmd.Stdout = tmp
 
 	if err := cmd.Start(); err != nil {
 		return err
 	}
 	if err := cmd.Wait(); err != nil {
-		return err
+		return fmt.Errorf("failed to dump MySQL database '%s': %s", database, err)
 	}
 
-	// Calculate final size and reset for reading from.
-	_, err = tmp.Seek(0, 0)
+	return writeDatabaseDump(database, tmp, tw)
+}
+
+// Restores a database from an SQL dump as created by DumpDatabase().
+// The dump is streamed directly into the mysql client, so we don't
+// need to buffer it. MySQL has no notion of object ownership, the
+// user is ignored.
+func (sys MySQL) RestoreDatabase(database string, user string, r io.Reader) error {
+	defaults, err := sys.writeClientDefaults()
 	if err != nil {
 		return err
 	}
-	stat, err := tmp.Stat()
+	defer os.Remove(defaults)
+
+	cmd := exec.Command("mysql", "--defaults-extra-file="+defaults, database)
+	cmd.Stdin = r
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf(
+			"failed to restore MySQL database '%s': %s: %s",
+			database, err, secret.RedactString(string(out), sys.s.MySQL.Password),
+		)
+	}
+	log.Printf("database %s restored", database)
+	return nil
+}
+
+// Writes the credentials of the MySQL client tools into a temporary
+// option file, only readable by us. Passing credentials via command
+// line arguments would expose them to anyone able to list processes.
+// The caller must remove the file once done.
+func (sys MySQL) writeClientDefaults() (string, error) {
+	tmp, err := ioutil.TempFile("", "hoi_my_")
 	if err != nil {
-		return err
+		return "", err
 	}
-	size := stat.Size()
-	log.Printf("database %s dump created, is %d bytes", database, size)
-
-	header := &tar.Header{
-		Name:     fmt.Sprintf("database/%s.sql", database),
-		Size:     size,
-		ModTime:  time.Now(),
-		Typeflag: tar.TypeReg,
-		Mode:     0660,
-		Uid:      0,
-		Gid:      0,
-		Uname:    "root",
-		Gname:    "root",
-	}
-	if err := tw.WriteHeader(header); err != nil {
-		return err
+	defer tmp.Close()
+
+	if err := tmp.Chmod(0600); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
 	}
-	if _, err := io.Copy(tw, tmp); err != nil {
-		return err
+	contents := fmt.Sprintf("[client]\nuser=%s\n", quoteOptionValue(sys.s.MySQL.User))
+	if sys.s.MySQL.Password != "" {
+		contents += fmt.Sprintf("password=%s\n", quoteOptionValue(sys.s.MySQL.Password))
 	}
-	return nil
+	if _, err := tmp.WriteString(contents); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write MySQL client defaults: %s", err)
+	}
+	return tmp.Name(), nil
+}
+
+// Quotes a value for use in a MySQL option file, escaping
+// backslashes and double quotes.
+func quoteOptionValue(v string) string {
+	v = strings.Replace(v, "\\", "\\\\", -1)
+	v = strings.Replace(v, "\"", "\\\"", -1)
+	return "\"" + v + "\""
+}
+
+// Returns the statement EnsureDatabase() would issue. As the
+// statement is idempotent, it is always returned.
+func (sys MySQL) PlanEnsureDatabase(database string) ([]string, error) {
+	return []string{fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", database)}, nil
+}
+
+// Returns the statements EnsureUser() would issue, without issuing
+// them. Passwords are masked.
+func (sys MySQL) PlanEnsureUser(user string, password string) ([]string, error) {
+	stmts := make([]string, 0)
+
+	if err := sys.CheckRestrictedUser(user); err != nil {
+		return stmts, err
+	}
+	hasUser, err := sys.HasUser(user, sys.s.MySQL.AccountHost)
+	if err != nil {
+		return stmts, err
+	}
+	if !hasUser {
+		hasAnyUser, err := sys.HasAnyUser(user)
+		if err != nil {
+			return stmts, err
+		}
+		if !hasAnyUser {
+			return append(stmts, fmt.Sprintf("CREATE USER '%s'@'%s' IDENTIFIED BY '%s'", user, sys.s.MySQL.AccountHost, secret.Mask)), nil
+		}
+		stmts = append(stmts, fmt.Sprintf("UPDATE mysql.user SET host = '%s' WHERE user = '%s'", sys.s.MySQL.AccountHost, user))
+	}
+
+	hasPassword, err := sys.HasPassword(user, sys.s.MySQL.AccountHost, password)
+	if err != nil {
+		return stmts, err
+	}
+	if hasPassword {
+		return stmts, nil
+	}
+	if sys.s.MySQL.UseLegacy {
+		stmts = append(stmts, fmt.Sprintf("SET PASSWORD FOR '%s'@'%s' = PASSWORD('%s')", user, sys.s.MySQL.AccountHost, secret.Mask))
+	} else {
+		stmts = append(stmts, fmt.Sprintf("ALTER USER '%s'@'%s' IDENTIFIED BY '%s'", user, sys.s.MySQL.AccountHost, secret.Mask))
+	}
+	return stmts, nil
+}
+
+// Returns the statements EnsureGrant() would issue for privileges
+// the user hasn't been granted yet, without issuing them.
+func (sys MySQL) PlanEnsureGrant(user string, database string, privs []string) ([]string, error) {
+	stmts := make([]string, 0, len(privs))
+
+	if err := sys.CheckRestrictedUser(user); err != nil {
+		return stmts, err
+	}
+	hasUser, err := sys.HasUser(user, sys.s.MySQL.AccountHost)
+	if err != nil {
+		return stmts, err
+	}
+	granted := make(map[string]bool)
+
+	// A user that doesn't exist yet, has no privileges.
+	if hasUser {
+		sql := fmt.Sprintf("SHOW GRANTS FOR '%s'@'%s'", user, sys.s.MySQL.AccountHost)
+
+		rows, err := sys.conn.Query(sql)
+		if err != nil {
+			return stmts, fmt.Errorf("failed to list grants of MySQL user '%s': %s", user, err)
+		}
+		defer rows.Close()
+
+		lines := make([]string, 0)
+		for rows.Next() {
+			var line string
+			if err := rows.Scan(&line); err != nil {
+				return stmts, err
+			}
+			lines = append(lines, line)
+		}
+		if err := rows.Err(); err != nil {
+			return stmts, err
+		}
+		granted = parseMySQLGrants(lines, database)
+	}
+
+	for _, priv := range privs {
+		if granted[priv] || granted["ALL PRIVILEGES"] {
+			continue
+		}
+		stmts = append(stmts, fmt.Sprintf("GRANT %s ON %s.* TO '%s'@'%s'", priv, database, user, sys.s.MySQL.AccountHost))
+	}
+	return stmts, nil
+}
+
+var mysqlGrantRegex = regexp.MustCompile("^GRANT (.+) ON (\\S+) TO ")
+
+// Parses lines as returned by SHOW GRANTS, i.e. "GRANT SELECT, INSERT
+// ON `example`.* TO 'example'@'localhost'", into the privileges
+// granted on the database.
+func parseMySQLGrants(lines []string, database string) map[string]bool {
+	granted := make(map[string]bool)
+
+	for _, line := range lines {
+		m := mysqlGrantRegex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		// Wildcard characters in database names may be escaped.
+		target := strings.Replace(strings.Replace(m[2], "`", "", -1), "\\", "", -1)
+		if target != database+".*" {
+			continue
+		}
+		for _, priv := range strings.Split(m[1], ", ") {
+			granted[priv] = true
+		}
+	}
+	return granted
 }
@@ -0,0 +1,38 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package system
+
+import (
+	"testing"
+)
+
+func TestParseMySQLGrants(t *testing.T) {
+	lines := []string{
+		"GRANT USAGE ON *.* TO 'example'@'localhost' IDENTIFIED BY PASSWORD '*0000'",
+		"GRANT SELECT, INSERT, LOCK TABLES ON `example`.* TO 'example'@'localhost'",
+		"GRANT DROP ON `other`.* TO 'example'@'localhost'",
+		"GRANT UPDATE ON `example`.`users` TO 'example'@'localhost'",
+	}
+	granted := parseMySQLGrants(lines, "example")
+
+	for _, priv := range []string{"SELECT", "INSERT", "LOCK TABLES"} {
+		if !granted[priv] {
+			t.Errorf("expected %s to be granted", priv)
+		}
+	}
+	for _, priv := range []string{"USAGE", "DROP", "UPDATE"} {
+		if granted[priv] {
+			t.Errorf("expected %s not to be granted on database", priv)
+		}
+	}
+
+	granted = parseMySQLGrants([]string{
+		"GRANT ALL PRIVILEGES ON `example\\_db`.* TO 'example'@'localhost' WITH GRANT OPTION",
+	}, "example_db")
+	if !granted["ALL PRIVILEGES"] {
+		t.Error("expected all privileges to be granted on escaped database name")
+	}
+}
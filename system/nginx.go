@@ -20,7 +20,7 @@ import (
 
 var (
 	NGINXLock  sync.RWMutex
-	NGINXDirty bool
+	NGINXDirty DirtyFlag
 )
 
 func NewNGINX(p *project.Config, s *server.Config, conn *dbus.Conn) *NGINX {
@@ -35,59 +35,67 @@ type NGINX struct {
 
 // Installs just the server configuration.
 func (sys *NGINX) Install(path string) error {
-	ns := fmt.Sprintf("project_%s", sys.p.ID)
-	target := fmt.Sprintf("%s/%s_%s", sys.s.NGINX.RunPath, ns, filepath.Base(path))
+	target := sys.InstallPath(path)
 
 	if err := util.CopyFile(path, target); err != nil {
 		return fmt.Errorf("NGINX failed to install %s -> %s: %s", path, target, err)
 	}
-	NGINXDirty = true
+	NGINXDirty.Set()
 	return nil
 }
 
 func (sys *NGINX) Uninstall(server string) error {
-	ns := fmt.Sprintf("project_%s", sys.p.ID)
-	target := fmt.Sprintf("%s/%s_%s", sys.s.NGINX.RunPath, ns, server)
+	target := sys.InstallPath(server)
 
 	if err := os.Remove(target); err != nil {
 		return fmt.Errorf("NGINX failed to uninstall %s: %s", target, err)
 	}
-	NGINXDirty = true
+	NGINXDirty.Set()
 	return nil
 }
 
+// Returns the absolute path the given server configuration will be
+// installed to. Takes a path to a server file or a plain server name.
+func (sys NGINX) InstallPath(path string) string {
+	ns := fmt.Sprintf("project_%s", sys.p.ID)
+	return fmt.Sprintf("%s/%s_%s", sys.s.NGINX.RunPath, ns, filepath.Base(path))
+}
+
 func (sys *NGINX) Reload() error {
 	NGINXLock.Lock()
 	defer NGINXLock.Unlock()
 
+	NGINXDirty.Clear()
 	done := make(chan string)
 
 	if _, err := sys.conn.ReloadUnit("nginx.service", "replace", done); err != nil {
+		NGINXDirty.Set()
 		return fmt.Errorf("failed to reload NGINX; possibly left in dirty state: %s", err)
 	}
 	if r := <-done; r != "done" {
+		NGINXDirty.Set()
 		return fmt.Errorf("failed to reload NGINX; systemd job states: %s", r)
 	}
-	NGINXDirty = false
 	return nil
 }
 
 func (sys *NGINX) ReloadIfDirty() error {
-	if !NGINXDirty {
-		return nil
-	}
 	NGINXLock.Lock()
 	defer NGINXLock.Unlock()
 
+	if !NGINXDirty.Clear() {
+		return nil
+	}
 	done := make(chan string)
 
 	if _, err := sys.conn.ReloadUnit("nginx.service", "replace", done); err != nil {
+		NGINXDirty.Set()
 		return fmt.Errorf("failed to reload NGINX; left in dirty state: %s", err)
 	}
 	if r := <-done; r != "done" {
+		NGINXDirty.Set()
 		return fmt.Errorf("failed to reload NGINX; systemd job states: %s", r)
 	}
-	NGINXDirty = false
 	return nil
 }
 
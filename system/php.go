@@ -17,8 +17,10 @@ import (
 )
 
 var (
-	PHPLock  sync.RWMutex
-	PHPDirty bool
+	PHPLock sync.RWMutex
+	// Tracks dirtiness per PHP service, as projects may use
+	// different PHP versions.
+	PHPDirty DirtySet
 )
 
 func NewPHP(p *project.Config, s *server.Config, conn *dbus.Conn) *PHP {
@@ -33,37 +35,70 @@ type PHP struct {
 
 // Installs just the server configuration.
 func (sys PHP) Install(path string) error {
-	runPath, err := sys.p.App.GetRunPath(sys.p, sys.s)
+	target, err := sys.InstallPath()
 	if err != nil {
 		return err
 	}
-	target := fmt.Sprintf("%s/99-project-%s.ini", runPath, sys.p.ID)
 
 	if err := util.CopyFile(path, target); err != nil {
 		return fmt.Errorf("PHP failed to install %s -> %s: %s", path, target, err)
 	}
-	PHPDirty = true
-	return nil
+	return sys.setDirty()
 }
 
 func (sys PHP) Uninstall() error {
-	runPath, err := sys.p.App.GetRunPath(sys.p, sys.s)
+	target, err := sys.InstallPath()
 	if err != nil {
 		return err
 	}
-	target := fmt.Sprintf("%s/99-project-%s.ini", runPath, sys.p.ID)
 
 	if err := os.Remove(target); err != nil {
 		return fmt.Errorf("PHP failed to uninstall %s: %s", target, err)
 	}
-	PHPDirty = true
+	return sys.setDirty()
+}
+
+func (sys PHP) setDirty() error {
+	service, err := sys.p.App.GetService(sys.p, sys.s)
+	if err != nil {
+		return err
+	}
+	PHPDirty.Set(service)
 	return nil
 }
 
-func (sys PHP) ReloadIfDirty() error {
-	if !PHPDirty {
+// Returns the absolute path the project's PHP-FPM pool configuration
+// will be installed to.
+func (sys PHP) InstallPath() (string, error) {
+	poolPath, err := sys.p.App.GetPoolPath(sys.p, sys.s)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/project-%s.conf", poolPath, sys.p.ID), nil
+}
+
+// Removes PHP settings installed before each project got its own
+// pool, if there are any left.
+func (sys PHP) UninstallLegacy() error {
+	if sys.s.PHP.RunPath == "" {
 		return nil
 	}
+	runPath, err := sys.p.App.GetRunPath(sys.p, sys.s)
+	if err != nil {
+		return err
+	}
+	target := fmt.Sprintf("%s/99-project-%s.ini", runPath, sys.p.ID)
+
+	if _, err := os.Stat(target); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.Remove(target); err != nil {
+		return fmt.Errorf("PHP failed to uninstall %s: %s", target, err)
+	}
+	return sys.setDirty()
+}
+
+func (sys PHP) ReloadIfDirty() error {
 	service, err := sys.p.App.GetService(sys.p, sys.s)
 	if err != nil {
 		return err
@@ -71,24 +106,27 @@ func (sys PHP) ReloadIfDirty() error {
 	PHPLock.Lock()
 	defer PHPLock.Unlock()
 
+	if !PHPDirty.Clear(service) {
+		return nil
+	}
 	done := make(chan string)
 
 	if _, err := sys.conn.ReloadUnit(service, "replace", done); err != nil {
+		PHPDirty.Set(service)
 		return fmt.Errorf("failed to reload PHP; left in dirty state: %s", err)
 	}
 	if r := <-done; r != "done" {
+		PHPDirty.Set(service)
 		return fmt.Errorf("failed to reload PHP; systemd job states: %s", r)
 	}
-	PHPDirty = false
 	return nil
 }
 
 func (sys PHP) IsInstalled() (bool, error) {
-	runPath, err := sys.p.App.GetRunPath(sys.p, sys.s)
+	file, err := sys.InstallPath()
 	if err != nil {
 		return false, err
 	}
-	file := fmt.Sprintf("%s/99-project-%s.ini", runPath, sys.p.ID)
 	_, err = os.Stat(file)
 	return !os.IsNotExist(err), nil
 }
@@ -0,0 +1,511 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package system
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/atelierdisko/hoi/project"
+	"github.com/atelierdisko/hoi/secret"
+	"github.com/atelierdisko/hoi/server"
+)
+
+func NewPostgreSQL(p *project.Config, s *server.Config, conn *sql.DB) *PostgreSQL {
+	return &PostgreSQL{p: p, s: s, conn: conn}
+}
+
+// PostgreSQL manages databases and roles on a PostgreSQL server. In
+// contrast to MySQL, changed privileges take effect immediately.
+type PostgreSQL struct {
+	p    *project.Config
+	s    *server.Config
+	conn *sql.DB
+}
+
+func (sys PostgreSQL) HasDatabase(database string) (bool, error) {
+	var count int
+
+	err := sys.conn.QueryRow(`SELECT COUNT(*) FROM pg_database WHERE datname = $1`, database).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for PostgreSQL database '%s': %s", database, err)
+	}
+	return count > 0, nil
+}
+
+func (sys PostgreSQL) EnsureDatabase(database string) error {
+	hasDatabase, err := sys.HasDatabase(database)
+	if err != nil {
+		return err
+	}
+	if hasDatabase {
+		return nil
+	}
+	if _, err := sys.conn.Exec(fmt.Sprintf("CREATE DATABASE %s", quoteIdent(database))); err != nil {
+		return fmt.Errorf("failed creating PostgreSQL database '%s': %s", database, err)
+	}
+	return nil
+}
+
+func (sys PostgreSQL) HasUser(user string) (bool, error) {
+	var count int
+
+	err := sys.conn.QueryRow(`SELECT COUNT(*) FROM pg_roles WHERE rolname = $1`, user).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for PostgreSQL role '%s': %s", user, err)
+	}
+	return count > 0, nil
+}
+
+// Checks whether the role we are connected as is a superuser, as
+// required by HasPassword().
+func (sys PostgreSQL) IsSuperuser() (bool, error) {
+	var isSuperuser bool
+
+	err := sys.conn.QueryRow(`SELECT rolsuper FROM pg_roles WHERE rolname = current_user`).Scan(&isSuperuser)
+	if err != nil {
+		return false, fmt.Errorf("failed to query attributes of PostgreSQL role: %s", err)
+	}
+	return isSuperuser, nil
+}
+
+// Verifies the password against the one stored for the role. Both
+// MD5 and SCRAM-SHA-256 password hashes are supported. Password
+// hashes are only readable by superusers, see IsSuperuser().
+func (sys PostgreSQL) HasPassword(user string, password string) (bool, error) {
+	var stored sql.NullString
+
+	err := sys.conn.QueryRow(`SELECT rolpassword FROM pg_authid WHERE rolname = $1`, user).Scan(&stored)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to verify password of PostgreSQL role '%s': %s", user, secret.RedactError(err, password))
+	}
+	if !stored.Valid {
+		return false, nil
+	}
+	return verifyPostgresPassword(stored.String, user, password), nil
+}
+
+func (sys PostgreSQL) EnsureUser(user string, password string) error {
+	if err := sys.CheckRestrictedUser(user); err != nil {
+		return err
+	}
+
+	hasUser, err := sys.HasUser(user)
+	if err != nil {
+		return err
+	}
+	if !hasUser {
+		sql := fmt.Sprintf("CREATE ROLE %s LOGIN PASSWORD %s", quoteIdent(user), quoteLiteral(password))
+
+		if _, err := sys.conn.Exec(sql); err != nil {
+			return fmt.Errorf("failed creating PostgreSQL role '%s': %s", user, secret.RedactError(err, password))
+		}
+		return nil
+	}
+
+	hasPassword, err := sys.HasPassword(user, password)
+	if err != nil {
+		return err
+	}
+	if hasPassword {
+		return nil
+	}
+	log.Printf("changing PostgreSQL password for role '%s'", user)
+
+	sql := fmt.Sprintf("ALTER ROLE %s WITH LOGIN PASSWORD %s", quoteIdent(user), quoteLiteral(password))
+	if _, err := sys.conn.Exec(sql); err != nil {
+		return fmt.Errorf("failed setting new password for PostgreSQL role '%s': %s", user, secret.RedactError(err, password))
+	}
+	return nil
+}
+
+// Grants the privileges on database level. Additionally allows the
+// user to use the public schema and - if the user may create
+// objects - to create objects inside it. Objects created by the
+// user are owned by it, so there is no need to grant privileges on
+// tables.
+func (sys PostgreSQL) EnsureGrant(user string, database string, privs []string) error {
+	if err := sys.CheckRestrictedUser(user); err != nil {
+		return err
+	}
+
+	hasUser, err := sys.HasUser(user)
+	if err != nil {
+		return err
+	}
+	if !hasUser {
+		return nil // do not even try to grant
+	}
+
+	for _, priv := range privs {
+		sql := fmt.Sprintf("GRANT %s ON DATABASE %s TO %s", priv, quoteIdent(database), quoteIdent(user))
+
+		if _, err := sys.conn.Exec(sql); err != nil {
+			return fmt.Errorf("failed granting PostgreSQL role '%s' privilege '%s' on '%s': %s", user, priv, database, err)
+		}
+	}
+	sql := fmt.Sprintf("GRANT %s ON SCHEMA public TO %s", strings.Join(schemaPrivs(privs), ", "), quoteIdent(user))
+
+	if err := sys.execOn(database, sql); err != nil {
+		return fmt.Errorf("failed granting PostgreSQL role '%s' privileges on schema of '%s': %s", user, database, err)
+	}
+	return nil
+}
+
+func (sys PostgreSQL) EnsureNoGrant(user string, database string, privs []string) error {
+	if err := sys.CheckRestrictedUser(user); err != nil {
+		return err
+	}
+
+	hasUser, err := sys.HasUser(user)
+	if err != nil {
+		return err
+	}
+	if !hasUser {
+		return nil // do not even try to revoke grants
+	}
+	hasDatabase, err := sys.HasDatabase(database)
+	if err != nil {
+		return err
+	}
+	if !hasDatabase {
+		return nil
+	}
+
+	for _, priv := range privs {
+		sql := fmt.Sprintf("REVOKE %s ON DATABASE %s FROM %s", priv, quoteIdent(database), quoteIdent(user))
+
+		if _, err := sys.conn.Exec(sql); err != nil {
+			log.Printf("skipped revoke for PostgreSQL role %s, privilege %s on %s: %s", user, priv, database, err)
+		}
+	}
+	sql := fmt.Sprintf("REVOKE %s ON SCHEMA public FROM %s", strings.Join(schemaPrivs(privs), ", "), quoteIdent(user))
+
+	if err := sys.execOn(database, sql); err != nil {
+		log.Printf("skipped revoke for PostgreSQL role %s, privileges on schema of %s: %s", user, database, err)
+	}
+	return nil
+}
+
+// Privileges take effect immediately, there is nothing to reload.
+func (sys *PostgreSQL) ReloadIfDirty() error {
+	return nil
+}
+
+// Ensures we don't manipulate general protected (admin) roles.
+func (sys PostgreSQL) CheckRestrictedUser(user string) error {
+	if user == "postgres" || user == sys.s.PostgreSQL.User {
+		return fmt.Errorf("is PostgreSQL restricted user: %s", user)
+	}
+	return nil
+}
+
+// Executes the statement while being connected to the given
+// database, required for statements on objects inside it.
+func (sys PostgreSQL) execOn(database string, stmt string) error {
+	conn, err := sql.Open("postgres", sys.s.PostgreSQL.DSN(database))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Exec(stmt)
+	return err
+}
+
+// Dumps the database in plain SQL format. Ownership and privileges
+// are not included, as the database may be restored under a
+// different user.
+func (sys PostgreSQL) DumpDatabase(database string, tw *tar.Writer) error {
+	tmp, err := ioutil.TempFile("", "hoi_")
+	if err != nil {
+		return err
+	}
+	defer tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	passfile, err := sys.writePassFile()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(passfile)
+
+	args := append(sys.clientArgs(), "--no-owner", "--no-privileges", database)
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("pg_dump", args...)
+	cmd.Env = append(os.Environ(), "PGPASSFILE="+passfile)
+	cmd.Stdout = tmp
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to dump PostgreSQL database '%s': %s: %s", database, err, stderr.String())
+	}
+	return writeDatabaseDump(database, tmp, tw)
+}
+
+// Restores a database from an SQL dump as created by DumpDatabase().
+// Statements are executed as the given user, so it owns all
+// restored objects.
+func (sys PostgreSQL) RestoreDatabase(database string, user string, r io.Reader) error {
+	passfile, err := sys.writePassFile()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(passfile)
+
+	args := append(sys.clientArgs(), "--set", "ON_ERROR_STOP=1", "--dbname", database)
+
+	cmd := exec.Command("psql", args...)
+	cmd.Env = append(os.Environ(), "PGPASSFILE="+passfile)
+	cmd.Stdin = io.MultiReader(
+		strings.NewReader(fmt.Sprintf("SET ROLE %s;\n", quoteIdent(user))),
+		r,
+	)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf(
+			"failed to restore PostgreSQL database '%s': %s: %s",
+			database, err, secret.RedactString(string(out), sys.s.PostgreSQL.Password),
+		)
+	}
+	log.Printf("database %s restored", database)
+	return nil
+}
+
+// Returns the statement EnsureDatabase() would issue, if any.
+func (sys PostgreSQL) PlanEnsureDatabase(database string) ([]string, error) {
+	hasDatabase, err := sys.HasDatabase(database)
+	if err != nil || hasDatabase {
+		return []string{}, err
+	}
+	return []string{fmt.Sprintf("CREATE DATABASE %s", quoteIdent(database))}, nil
+}
+
+// Returns the statements EnsureUser() would issue, without issuing
+// them. Passwords are masked.
+func (sys PostgreSQL) PlanEnsureUser(user string, password string) ([]string, error) {
+	stmts := make([]string, 0)
+
+	if err := sys.CheckRestrictedUser(user); err != nil {
+		return stmts, err
+	}
+	hasUser, err := sys.HasUser(user)
+	if err != nil {
+		return stmts, err
+	}
+	if !hasUser {
+		return append(stmts, fmt.Sprintf("CREATE ROLE %s LOGIN PASSWORD '%s'", quoteIdent(user), secret.Mask)), nil
+	}
+	hasPassword, err := sys.HasPassword(user, password)
+	if err != nil {
+		return stmts, err
+	}
+	if hasPassword {
+		return stmts, nil
+	}
+	return append(stmts, fmt.Sprintf("ALTER ROLE %s WITH LOGIN PASSWORD '%s'", quoteIdent(user), secret.Mask)), nil
+}
+
+// Returns the statements EnsureGrant() would issue for privileges
+// the user hasn't been granted yet, without issuing them.
+func (sys PostgreSQL) PlanEnsureGrant(user string, database string, privs []string) ([]string, error) {
+	stmts := make([]string, 0, len(privs)+1)
+
+	if err := sys.CheckRestrictedUser(user); err != nil {
+		return stmts, err
+	}
+	hasUser, err := sys.HasUser(user)
+	if err != nil {
+		return stmts, err
+	}
+	hasDatabase, err := sys.HasDatabase(database)
+	if err != nil {
+		return stmts, err
+	}
+	// Without user or database, no privileges can have been granted.
+	exists := hasUser && hasDatabase
+
+	for _, priv := range privs {
+		if exists {
+			var granted bool
+
+			err := sys.conn.QueryRow(`SELECT has_database_privilege($1, $2, $3)`, user, database, priv).Scan(&granted)
+			if err != nil {
+				return stmts, fmt.Errorf("failed to check privilege '%s' of PostgreSQL role '%s' on '%s': %s", priv, user, database, err)
+			}
+			if granted {
+				continue
+			}
+		}
+		stmts = append(stmts, fmt.Sprintf("GRANT %s ON DATABASE %s TO %s", priv, quoteIdent(database), quoteIdent(user)))
+	}
+
+	missing := make([]string, 0)
+	for _, priv := range schemaPrivs(privs) {
+		if exists {
+			granted, err := sys.hasSchemaPrivilege(user, database, priv)
+			if err != nil {
+				return stmts, err
+			}
+			if granted {
+				continue
+			}
+		}
+		missing = append(missing, priv)
+	}
+	if len(missing) == 0 {
+		return stmts, nil
+	}
+	return append(stmts, fmt.Sprintf(
+		"GRANT %s ON SCHEMA public TO %s -- in %s",
+		strings.Join(missing, ", "), quoteIdent(user), quoteIdent(database),
+	)), nil
+}
+
+// Checks whether the user has the privilege on the public schema of
+// the database. Schemas are local to a database, so we must connect
+// to it.
+func (sys PostgreSQL) hasSchemaPrivilege(user string, database string, priv string) (bool, error) {
+	conn, err := sql.Open("postgres", sys.s.PostgreSQL.DSN(database))
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var granted bool
+	err = conn.QueryRow(`SELECT has_schema_privilege($1, 'public', $2)`, user, priv).Scan(&granted)
+	if err != nil {
+		return false, fmt.Errorf("failed to check privilege '%s' of PostgreSQL role '%s' on schema of '%s': %s", priv, user, database, err)
+	}
+	return granted, nil
+}
+
+// Arguments to connect the PostgreSQL client tools to the server.
+// The password is passed via a password file.
+func (sys PostgreSQL) clientArgs() []string {
+	host, port := sys.s.PostgreSQL.GetHostPort()
+
+	return []string{
+		"--host", host,
+		"--port", port,
+		"--username", sys.s.PostgreSQL.User,
+		"--no-password",
+	}
+}
+
+// Writes the password of the PostgreSQL client tools into a
+// temporary password file, only readable by us. The caller must
+// remove the file once done.
+func (sys PostgreSQL) writePassFile() (string, error) {
+	tmp, err := ioutil.TempFile("", "hoi_pg_")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	if err := tmp.Chmod(0600); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	password := strings.Replace(sys.s.PostgreSQL.Password, `\`, `\\`, -1)
+	password = strings.Replace(password, `:`, `\:`, -1)
+
+	if _, err := tmp.WriteString(fmt.Sprintf("*:*:*:*:%s\n", password)); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write PostgreSQL password file: %s", err)
+	}
+	return tmp.Name(), nil
+}
+
+// Maps database level to schema level privileges: users can always
+// use the public schema, users allowed to create objects inside the
+// database may create them inside the public schema.
+func schemaPrivs(privs []string) []string {
+	schema := []string{"USAGE"}
+
+	for _, priv := range privs {
+		if priv == "CREATE" {
+			schema = append(schema, "CREATE")
+		}
+	}
+	return schema
+}
+
+func quoteIdent(v string) string {
+	return `"` + strings.Replace(v, `"`, `""`, -1) + `"`
+}
+
+// Quotes a string literal, assumes standard_conforming_strings is
+// enabled, which is the default since PostgreSQL 9.1.
+func quoteLiteral(v string) string {
+	return `'` + strings.Replace(v, `'`, `''`, -1) + `'`
+}
+
+// Checks the password against a hash as stored in pg_authid. The
+// hash is either "md5" followed by the hex encoded MD5 of password
+// and user or "SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>".
+func verifyPostgresPassword(stored string, user string, password string) bool {
+	if strings.HasPrefix(stored, "md5") {
+		sum := md5.Sum([]byte(password + user))
+		expected := "md5" + hex.EncodeToString(sum[:])
+
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(expected)) == 1
+	}
+	if !strings.HasPrefix(stored, "SCRAM-SHA-256$") {
+		return false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(stored, "SCRAM-SHA-256$"), "$", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	params := strings.SplitN(parts[0], ":", 2)
+	keys := strings.SplitN(parts[1], ":", 2)
+	if len(params) != 2 || len(keys) != 2 {
+		return false
+	}
+	iterations, err := strconv.Atoi(params[0])
+	if err != nil {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(params[1])
+	if err != nil {
+		return false
+	}
+	storedKey, err := base64.StdEncoding.DecodeString(keys[0])
+	if err != nil {
+		return false
+	}
+	salted, err := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, salted)
+	mac.Write([]byte("Client Key"))
+	clientKey := sha256.Sum256(mac.Sum(nil))
+
+	return hmac.Equal(clientKey[:], storedKey)
+}
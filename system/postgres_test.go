@@ -0,0 +1,40 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package system
+
+import (
+	"testing"
+)
+
+func TestVerifyPostgresPassword(t *testing.T) {
+	scram := "SCRAM-SHA-256$4096:MDEyMzQ1Njc4OWFiY2RlZg==$MiuIWMPE8f1wvUs8gqR/HUOBiaxP2InTIltSNS8murY=:UKlEJeK+9JbWnqN1OJJFASpGl2gC6h5m1W8s9eH3q3Q="
+	md5 := "md5d1360be0d9cdf6bf5af2cb42bd327b72"
+
+	tests := []struct {
+		stored   string
+		user     string
+		password string
+		expected bool
+	}{
+		{scram, "example", "s3cret", true},
+		{scram, "other", "s3cret", true},
+		{scram, "example", "s3cret2", false},
+		{scram, "example", "", false},
+		{md5, "example", "s3cret", true},
+		{md5, "other", "s3cret", false},
+		{md5, "example", "s3cret2", false},
+		{"SCRAM-SHA-256$4096:MDEy", "example", "s3cret", false},
+		{"SCRAM-SHA-256$x:MDEyMzQ1Njc4OWFiY2RlZg==$MiuI:UKlE", "example", "s3cret", false},
+		{"s3cret", "example", "s3cret", false},
+		{"", "example", "", false},
+	}
+	for _, test := range tests {
+		r := verifyPostgresPassword(test.stored, test.user, test.password)
+		if r != test.expected {
+			t.Errorf("for %s, %s, %s result: %v | expected: %v", test.stored, test.user, test.password, r, test.expected)
+		}
+	}
+}
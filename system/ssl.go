@@ -6,11 +6,21 @@
 package system
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/atelierdisko/hoi/project"
 	"github.com/atelierdisko/hoi/server"
@@ -18,9 +28,9 @@ import (
 )
 
 var (
-	// no need for mutex: all actions are atomic, we
-	// do not reload the whole configuration
-	SSLDirty bool
+	// Indicates that NGINX must be reloaded to pick up changed
+	// certificates.
+	SSLDirty DirtyFlag
 )
 
 func NewSSL(p *project.Config, s *server.Config) *SSL {
@@ -38,6 +48,9 @@ type SSL struct {
 }
 
 func (sys *SSL) Install(domain string, ssl project.SSLDirective) error {
+	if ssl.IsACME() {
+		return sys.installACME(domain)
+	}
 	ns := fmt.Sprintf("project_%s", sys.p.ID)
 
 	targetKey := fmt.Sprintf("%s/private/%s_%s.key", sys.s.SSL.RunPath, ns, domain)
@@ -64,7 +77,7 @@ func (sys *SSL) Install(domain string, ssl project.SSLDirective) error {
 			return fmt.Errorf("failed to copy project SSL cert key %s -> %s: %s", sourceKey, targetKey, err)
 		}
 	}
-	SSLDirty = true // is now dirty, ensure is set, we might exit below
+	SSLDirty.Set() // is now dirty, ensure is set, we might exit below
 
 	targetCert := fmt.Sprintf("%s/certs/%s_%s.crt", sys.s.SSL.RunPath, ns, domain)
 
@@ -117,7 +130,7 @@ func (sys *SSL) Uninstall(domain string) error {
 	if err := os.Remove(target); err != nil {
 		return fmt.Errorf("failed to uninstall SSL cert %s: %s", target, err)
 	}
-	SSLDirty = true
+	SSLDirty.Set()
 
 	target = fmt.Sprintf("%s/private/%s_%s.key", sys.s.SSL.RunPath, ns, domain)
 	if err := os.Remove(target); err != nil {
@@ -165,3 +178,281 @@ func (sys SSL) GetCertificateKey(fqdn string) (string, error) {
 	}
 	return target, nil
 }
+
+// Parses the installed certificate for given FQDN.
+func (sys SSL) InspectCertificate(fqdn string) (*x509.Certificate, error) {
+	file, err := sys.GetCertificate(fqdn)
+	if err != nil {
+		return nil, fmt.Errorf("no SSL cert installed for %s", fqdn)
+	}
+	return ParseCertificateFile(file)
+}
+
+// Parses the first certificate of a PEM encoded file, which is the
+// leaf certificate of a chain.
+func ParseCertificateFile(file string) (*x509.Certificate, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSL cert %s: %s", file, err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("failed to decode SSL cert %s: no PEM encoded certificate found", file)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSL cert %s: %s", file, err)
+	}
+	return cert, nil
+}
+
+// Describes what installing certificate and key for the domain would
+// do, without installing them. Key contents are never compared
+// or disclosed.
+func (sys SSL) Plan(domain string, ssl project.SSLDirective) ([]string, error) {
+	notes := make([]string, 0)
+
+	targetKey, _ := sys.GetCertificateKey(domain)
+	switch ssl.CertificateKey {
+	case project.CertKeySystem:
+		sourceKey, err := sys.s.SSL.GetSystemCertificateKey(domain)
+		if err != nil {
+			return notes, err
+		}
+		notes = append(notes, fmt.Sprintf("install system SSL cert key %s -> %s", sourceKey, targetKey))
+	case project.CertKeyGenerate:
+		notes = append(notes, fmt.Sprintf("generate SSL cert key %s", targetKey))
+	case project.CertKeyACME:
+		notes = append(notes, fmt.Sprintf("install ACME SSL cert key %s", targetKey))
+	default:
+		sourceKey := filepath.Join(sys.p.Path, ssl.CertificateKey)
+		notes = append(notes, fmt.Sprintf("install project SSL cert key %s -> %s", sourceKey, targetKey))
+	}
+
+	targetCert, _ := sys.GetCertificate(domain)
+	var sourceCert string
+
+	switch ssl.Certificate {
+	case project.CertSystem:
+		source, err := sys.s.SSL.GetSystemCertificate(domain)
+		if err != nil {
+			return notes, err
+		}
+		sourceCert = source
+	case project.CertSelfSigned:
+		notes = append(notes, fmt.Sprintf("generate self-signed SSL cert %s", targetCert))
+		return notes, nil
+	case project.CertACME:
+		sourceCert, _ = sys.getACMEFiles(domain)
+
+		if _, err := os.Stat(sourceCert); os.IsNotExist(err) {
+			notes = append(notes, fmt.Sprintf("install placeholder SSL cert %s, until ACME cert is obtained", targetCert))
+			return notes, nil
+		}
+	default:
+		sourceCert = filepath.Join(sys.p.Path, ssl.Certificate)
+	}
+
+	state := "new"
+	if current, err := ioutil.ReadFile(targetCert); err == nil {
+		next, err := ioutil.ReadFile(sourceCert)
+		if err != nil {
+			return notes, fmt.Errorf("failed to read SSL cert %s: %s", sourceCert, err)
+		}
+		if bytes.Equal(current, next) {
+			state = "unchanged"
+		} else {
+			state = "changed"
+		}
+	}
+	notes = append(notes, fmt.Sprintf("install SSL cert %s -> %s (%s)", sourceCert, targetCert, state))
+	return notes, nil
+}
+
+// Paths to the cert and key obtained via ACME for given domain. Both
+// are kept in the ACME state directory and installed from there.
+func (sys SSL) getACMEFiles(domain string) (string, string) {
+	return filepath.Join(sys.s.SSL.ACME.StatePath, "certs", domain+".crt"),
+		filepath.Join(sys.s.SSL.ACME.StatePath, "private", domain+".key")
+}
+
+// Installs a previously obtained ACME cert and key. If there is none
+// yet, installs a short-lived self-signed placeholder, so NGINX can
+// start serving the domain and answer the challenges, which are
+// needed to obtain the real cert; see RenewACME().
+func (sys *SSL) installACME(domain string) error {
+	if !sys.s.SSL.ACME.Enabled {
+		return fmt.Errorf("failed to install ACME SSL cert for %s: ACME is not enabled in server configuration", domain)
+	}
+	targetCert, _ := sys.GetCertificate(domain)
+	targetKey, _ := sys.GetCertificateKey(domain)
+	sourceCert, sourceKey := sys.getACMEFiles(domain)
+
+	cert, certErr := ioutil.ReadFile(sourceCert)
+	key, keyErr := ioutil.ReadFile(sourceKey)
+
+	if certErr != nil || keyErr != nil {
+		var err error
+		if cert, key, err = generatePlaceholderCert(domain); err != nil {
+			return fmt.Errorf("failed to generate placeholder SSL cert for %s: %s", domain, err)
+		}
+	}
+	if err := writeCertPair(targetCert, cert, targetKey, key); err != nil {
+		return fmt.Errorf("failed to install ACME SSL cert for %s: %s", domain, err)
+	}
+	SSLDirty.Set()
+	return nil
+}
+
+// Obtains a new cert via ACME for the domain - covering all its names
+// - when there is none yet, the current one expires soon or does not
+// cover all names anymore. Installs the cert, if it differs from the
+// installed one. The given client is shared by all domains renewed
+// during a run.
+func (sys *SSL) RenewACME(d project.DomainDirective, acme *ACME) error {
+	sourceCert, sourceKey := sys.getACMEFiles(d.FQDN)
+
+	renew, err := sys.needsRenewal(sourceCert, d.GetNames())
+	if err != nil {
+		return err
+	}
+	if renew {
+		log.Printf("obtaining ACME SSL cert for %s", d.FQDN)
+
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return fmt.Errorf("failed to generate SSL cert key for %s: %s", d.FQDN, err)
+		}
+		cert, err := acme.Obtain(d.GetNames(), key)
+		if err != nil {
+			return fmt.Errorf("failed to obtain ACME SSL cert for %s: %s", d.FQDN, err)
+		}
+
+		for _, dir := range []string{filepath.Dir(sourceCert), filepath.Dir(sourceKey)} {
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return fmt.Errorf("failed to create ACME state directory %s: %s", dir, err)
+			}
+		}
+		keyPEM := pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})
+		if err := writeCertPair(sourceCert, cert, sourceKey, keyPEM); err != nil {
+			return fmt.Errorf("failed to save ACME SSL cert for %s: %s", d.FQDN, err)
+		}
+		log.Printf("obtained ACME SSL cert for %s", d.FQDN)
+	}
+
+	targetCert, _ := sys.GetCertificate(d.FQDN)
+	current, _ := ioutil.ReadFile(targetCert)
+	next, err := ioutil.ReadFile(sourceCert)
+	if err != nil {
+		return fmt.Errorf("failed to read ACME SSL cert %s: %s", sourceCert, err)
+	}
+	if bytes.Equal(current, next) {
+		return nil
+	}
+	return sys.installACME(d.FQDN)
+}
+
+// Writes cert and key to temporary files next to their targets and
+// then renames them into place, the key last. A failed write leaves
+// the currently installed pair untouched.
+func writeCertPair(certFile string, cert []byte, keyFile string, key []byte) error {
+	tmpCert, err := writeTemp(certFile, cert, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpCert)
+
+	tmpKey, err := writeTemp(keyFile, key, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpKey)
+
+	if err := os.Rename(tmpCert, certFile); err != nil {
+		return err
+	}
+	return os.Rename(tmpKey, keyFile)
+}
+
+// Writes data to a temporary file in the directory of file and
+// returns the temporary file's path.
+func writeTemp(file string, data []byte, perm os.FileMode) (string, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".")
+	if err != nil {
+		return "", err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// Checks whether the given cert is missing, about to expire or does
+// not cover all names.
+func (sys SSL) needsRenewal(file string, names []string) (bool, error) {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return true, nil
+	}
+	cert, err := ParseCertificateFile(file)
+	if err != nil {
+		return true, nil
+	}
+	before, err := sys.s.SSL.ACME.GetRenewBefore()
+	if err != nil {
+		return false, fmt.Errorf("failed to parse ACME renewBefore: %s", err)
+	}
+	if time.Now().Add(before).After(cert.NotAfter) {
+		return true, nil
+	}
+	for _, name := range names {
+		if cert.VerifyHostname(name) != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Generates a self-signed cert and key, valid for a week.
+func generatePlaceholderCert(domain string) ([]byte, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain, "www." + domain},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(7 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	return cert, keyPEM, nil
+}
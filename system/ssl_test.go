@@ -0,0 +1,63 @@
+// Copyright 2018 Atelier Disko. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package system
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteCertPair(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "hoi-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	certFile := filepath.Join(tmp, "example.org.crt")
+	keyFile := filepath.Join(tmp, "example.org.key")
+
+	ioutil.WriteFile(certFile, []byte("old cert"), 0644)
+	ioutil.WriteFile(keyFile, []byte("old key"), 0600)
+
+	if err := writeCertPair(certFile, []byte("new cert"), keyFile, []byte("new key")); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := ioutil.ReadFile(certFile)
+	key, _ := ioutil.ReadFile(keyFile)
+
+	if string(cert) != "new cert" || string(key) != "new key" {
+		t.Errorf("failed to replace pair, got: %q, %q", cert, key)
+	}
+	if fi, _ := os.Stat(keyFile); fi.Mode().Perm() != 0600 {
+		t.Errorf("expected key mode 0600, got: %s", fi.Mode())
+	}
+	if fi, _ := os.Stat(certFile); fi.Mode().Perm() != 0644 {
+		t.Errorf("expected cert mode 0644, got: %s", fi.Mode())
+	}
+
+	files, _ := ioutil.ReadDir(tmp)
+	if len(files) != 2 {
+		t.Errorf("expected no temporary files to be left, got %d files", len(files))
+	}
+
+	// Fails to write the key, the installed pair must stay untouched.
+	err = writeCertPair(certFile, []byte("newer cert"), filepath.Join(tmp, "missing", "example.org.key"), []byte("newer key"))
+	if err == nil {
+		t.Fatal("expected failure writing key")
+	}
+	cert, _ = ioutil.ReadFile(certFile)
+
+	if string(cert) != "new cert" {
+		t.Errorf("cert was replaced although key failed, got: %q", cert)
+	}
+	files, _ = ioutil.ReadDir(tmp)
+	if len(files) != 2 {
+		t.Errorf("expected no temporary files to be left, got %d files", len(files))
+	}
+}
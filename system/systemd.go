@@ -7,10 +7,13 @@ package system
 
 import (
 	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/atelierdisko/hoi/project"
 	"github.com/atelierdisko/hoi/server"
@@ -25,12 +28,14 @@ const (
 	SystemdKindCron       = "cron"
 	SystemdKindWorker     = "worker"
 	SystemdKindVolume     = "volume"
+	SystemdKindSlice      = "slice"
+	SystemdKindLocation   = "location"
 )
 
 var (
 	// SystemdDirty indicates wheter the systemd daemon needs to be
 	// reloaded. Flag will be reset once daemon reloaded.
-	SystemdDirty bool
+	SystemdDirty DirtyFlag
 )
 
 func NewSystemd(kind string, p *project.Config, s *server.Config, conn *dbus.Conn) *Systemd {
@@ -64,10 +69,17 @@ func (sys Systemd) EscapeUnitName(name string) string {
 // Mount unit names cannot be prefixed with a crafted project
 // namespace as they must reflect the actual path. They are however
 // naturally namespaced by the absolute project path.
+//
+// The project's slice is referred to by the other units of the
+// project. It is named "project_<id>.slice", its unprefixed name thus
+// is ".slice".
 func (sys Systemd) getPrefix() string {
 	if sys.kind == SystemdKindVolume {
 		return unit.UnitNamePathEscape(sys.p.Path) + "-"
 	}
+	if sys.kind == SystemdKindSlice {
+		return fmt.Sprintf("project_%s", sys.p.ID)
+	}
 	return fmt.Sprintf("project_%s_%s_", sys.p.ID, sys.kind)
 }
 
@@ -75,35 +87,60 @@ func (sys Systemd) getPrefix() string {
 // path to the source unit file. Using copies instead of symlinks is more robust:
 // not all locations are valid symlink targets (i.e. files under /etc).
 func (sys Systemd) Install(path string) error {
-	target := fmt.Sprintf("%s/%s%s", sys.s.Systemd.RunPath, sys.getPrefix(), filepath.Base(path))
+	target := sys.InstallPath(path)
 
 	if err := util.CopyFile(path, target); err != nil {
 		return fmt.Errorf("failed to copy systemd unit %s -> %s: %s", path, target, err)
 	}
-	SystemdDirty = true
+	SystemdDirty.Set()
 	return nil
 }
 
 // Removes a copy/link of a unit file inside the systemd configuration directory. Takes
 // the unprefixed unit name including type suffix (i.e. "example.service", "tmp-cache.mount").
 func (sys Systemd) Uninstall(unit string) error {
-	target := fmt.Sprintf("%s/%s%s", sys.s.Systemd.RunPath, sys.getPrefix(), unit)
+	target := sys.InstallPath(unit)
 
 	if err := os.Remove(target); err != nil {
 		return fmt.Errorf("failed to remove systemd unit %s: %s", target, err)
 	}
-	SystemdDirty = true
+	SystemdDirty.Set()
 	return nil
 }
 
+// Returns the absolute path the given unit file will be installed
+// to. Takes a path to a unit file or a plain unit name.
+func (sys Systemd) InstallPath(path string) string {
+	return fmt.Sprintf("%s/%s%s", sys.s.Systemd.RunPath, sys.getPrefix(), filepath.Base(path))
+}
+
+// Lists absolute paths of all unit files installed for the project.
+// In contrast to the ListInstalled*() methods, this does not query
+// systemd, but looks at the unit files directly.
+func (sys Systemd) ListInstalledFiles() ([]string, error) {
+	files := make([]string, 0)
+	prefix := sys.getPrefix()
+
+	fs, err := ioutil.ReadDir(sys.s.Systemd.RunPath)
+	if err != nil {
+		return files, fmt.Errorf("failed to list installed systemd unit files: %s", err)
+	}
+	for _, f := range fs {
+		if strings.HasPrefix(f.Name(), prefix) {
+			files = append(files, filepath.Join(sys.s.Systemd.RunPath, f.Name()))
+		}
+	}
+	return files, nil
+}
+
 func (sys Systemd) ReloadIfDirty() error {
-	if !SystemdDirty {
+	if !SystemdDirty.Clear() {
 		return nil
 	}
 	if err := sys.conn.Reload(); err != nil {
+		SystemdDirty.Set()
 		return fmt.Errorf("failed to reload systemd; left in dirty state: %s", err)
 	}
-	SystemdDirty = false
 	return nil
 }
 
@@ -119,6 +156,10 @@ func (sys Systemd) ListInstalledMounts() ([]string, error) {
 	return sys.listInstalledUnits("mount")
 }
 
+func (sys Systemd) ListInstalledSockets() ([]string, error) {
+	return sys.listInstalledUnits("socket")
+}
+
 // Enables a unit for automatic startup at system boot and immediately starts the unit. Takes
 // an unprefixed unit name including the type suffix (i.e. "example.service", "tmp-cache.mount").
 func (sys Systemd) EnableAndStart(unit string) error {
@@ -172,6 +213,106 @@ func (sys Systemd) Stop(unit string) error {
 	return nil
 }
 
+// Restarts the unit, if it is running. Takes an unprefixed unit name
+// including the type suffix (i.e. "example.service").
+func (sys Systemd) TryRestart(unit string) error {
+	target := fmt.Sprintf("%s%s", sys.getPrefix(), unit)
+
+	_, err := sys.conn.TryRestartUnit(target, "replace", nil)
+	if err != nil {
+		return fmt.Errorf("failed to restart systemd unit %s: %s", target, err)
+	}
+	return nil
+}
+
+// Live status of a unit, as reported by systemd.
+type UnitStatus struct {
+	// Unprefixed unit name including its suffix (i.e. "example.service").
+	Unit        string
+	ActiveState string
+	SubState    string
+	// Main PID of services; 0 if not running.
+	MainPID uint32
+	// Memory currently used by services and slices in bytes; 0 if not
+	// available i.e. because memory accounting is disabled.
+	Memory uint64
+	// CPU time consumed by slices; 0 if not available.
+	CPUUsage time.Duration
+	// Number of automatic restarts of services.
+	Restarts uint32
+	// Timers only: when the timer last triggered and will trigger next;
+	// zero if never or not scheduled.
+	LastTrigger time.Time
+	NextTrigger time.Time
+	// Result of the last run, i.e. "success" or "exit-code". For
+	// timers the result of the service they trigger.
+	Result string
+}
+
+// Queries systemd for the live status of a unit. Takes an unprefixed
+// unit name including the type suffix (i.e. "example.service").
+func (sys Systemd) Status(unit string) (UnitStatus, error) {
+	target := fmt.Sprintf("%s%s", sys.getPrefix(), unit)
+	status := UnitStatus{Unit: unit}
+
+	props, err := sys.conn.GetUnitProperties(target)
+	if err != nil {
+		return status, fmt.Errorf("failed to query status of systemd unit %s: %s", target, err)
+	}
+	status.ActiveState, _ = props["ActiveState"].(string)
+	status.SubState, _ = props["SubState"].(string)
+
+	switch filepath.Ext(unit) {
+	case ".service":
+		props, err := sys.conn.GetUnitTypeProperties(target, "Service")
+		if err != nil {
+			return status, fmt.Errorf("failed to query status of systemd unit %s: %s", target, err)
+		}
+		status.MainPID, _ = props["MainPID"].(uint32)
+		status.Restarts, _ = props["NRestarts"].(uint32)
+		status.Result, _ = props["Result"].(string)
+
+		// Unavailable memory usage is reported as the max value.
+		if memory, ok := props["MemoryCurrent"].(uint64); ok && memory != math.MaxUint64 {
+			status.Memory = memory
+		}
+	case ".timer":
+		props, err := sys.conn.GetUnitTypeProperties(target, "Timer")
+		if err != nil {
+			return status, fmt.Errorf("failed to query status of systemd unit %s: %s", target, err)
+		}
+		if usec, ok := props["LastTriggerUSec"].(uint64); ok && usec != 0 {
+			status.LastTrigger = time.Unix(0, int64(usec)*int64(time.Microsecond))
+		}
+		if usec, ok := props["NextElapseUSecRealtime"].(uint64); ok && usec != 0 && usec != math.MaxUint64 {
+			status.NextTrigger = time.Unix(0, int64(usec)*int64(time.Microsecond))
+		}
+		service := strings.TrimSuffix(target, ".timer") + ".service"
+
+		if prop, err := sys.conn.GetServiceProperty(service, "Result"); err == nil {
+			status.Result, _ = prop.Value.Value().(string)
+		}
+	case ".slice":
+		props, err := sys.conn.GetUnitTypeProperties(target, "Slice")
+		if err != nil {
+			return status, fmt.Errorf("failed to query status of systemd unit %s: %s", target, err)
+		}
+		if memory, ok := props["MemoryCurrent"].(uint64); ok && memory != math.MaxUint64 {
+			status.Memory = memory
+		}
+		if nsec, ok := props["CPUUsageNSec"].(uint64); ok && nsec != math.MaxUint64 {
+			status.CPUUsage = time.Duration(nsec)
+		}
+	case ".mount":
+		props, err := sys.conn.GetUnitTypeProperties(target, "Mount")
+		if err != nil {
+			return status, fmt.Errorf("failed to query status of systemd unit %s: %s", target, err)
+		}
+		status.Result, _ = props["Result"].(string)
+	}
+	return status, nil
+}
+
 // Lists installed units. Strips prefix, leaving just the plain unit
 // name including its suffix (i.e. "example.service").
 func (sys Systemd) listInstalledUnits(suffix string) ([]string, error) {